@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireToken rejects requests whose Authorization header doesn't carry
+// token as a bearer token with 401 Unauthorized. An empty token disables
+// authentication.
+func requireToken(token string) gin.HandlerFunc {
+	if token == "" {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	want := []byte(token)
+	return func(c *gin.Context) {
+		// The comparison takes the same time however much of the token
+		// matches, so it can't be guessed a byte at a time.
+		if got, ok := bearerToken(c.GetHeader("Authorization")); !ok || subtle.ConstantTimeCompare([]byte(got), want) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="receipt-api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(CodeUnauthorized, "Missing or invalid bearer token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header value. The scheme is matched case-insensitively.
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"receipt-api/scoring"
+)
+
+// Config holds every server setting. Each field can be set in the config file
+// given with --config, under the name in its tags, and is overridden by the
+// environment variable named in its comment when that is set. See the
+// Configuration section of the README for what each one does.
+type Config struct {
+	Addr    string `json:"addr" yaml:"addr"`       // RECEIPT_API_ADDR; --addr overrides both
+	TLSCert string `json:"tlsCert" yaml:"tlsCert"` // RECEIPT_API_TLS_CERT
+	TLSKey  string `json:"tlsKey" yaml:"tlsKey"`   // RECEIPT_API_TLS_KEY
+
+	Store         string `json:"store" yaml:"store"`                 // RECEIPT_API_STORE
+	DataFile      string `json:"dataFile" yaml:"dataFile"`           // RECEIPT_API_DATA_FILE
+	StoreShards   int    `json:"storeShards" yaml:"storeShards"`     // RECEIPT_API_STORE_SHARDS
+	StoreCapacity int    `json:"storeCapacity" yaml:"storeCapacity"` // RECEIPT_API_STORE_CAPACITY
+	Deduplicate   bool   `json:"deduplicate" yaml:"deduplicate"`     // RECEIPT_API_DEDUPLICATE
+	TTL           string `json:"ttl" yaml:"ttl"`                     // RECEIPT_API_TTL, a duration such as "24h"
+	AsyncScoring  bool   `json:"asyncScoring" yaml:"asyncScoring"`   // RECEIPT_API_ASYNC_SCORING
+	WebhookURL    string `json:"webhookUrl" yaml:"webhookUrl"`       // RECEIPT_API_WEBHOOK_URL
+	RulesFile     string `json:"rulesFile" yaml:"rulesFile"`         // RECEIPT_API_RULES_FILE
+
+	MaxItems            int  `json:"maxItems" yaml:"maxItems"`                       // RECEIPT_API_MAX_ITEMS
+	MaxPoints           int  `json:"maxPoints" yaml:"maxPoints"`                     // RECEIPT_API_MAX_POINTS
+	MaxTotalCents       int  `json:"maxTotalCents" yaml:"maxTotalCents"`             // RECEIPT_API_MAX_TOTAL_CENTS
+	CheckTotal          bool `json:"checkTotal" yaml:"checkTotal"`                   // RECEIPT_API_CHECK_TOTAL
+	TotalToleranceCents int  `json:"totalToleranceCents" yaml:"totalToleranceCents"` // RECEIPT_API_TOTAL_TOLERANCE_CENTS
+	RoundTotal          bool `json:"roundTotal" yaml:"roundTotal"`                   // RECEIPT_API_ROUND_TOTAL
+	RejectFutureDates   bool `json:"rejectFutureDates" yaml:"rejectFutureDates"`     // RECEIPT_API_REJECT_FUTURE_DATES
+	StrictJSON          bool `json:"strictJson" yaml:"strictJson"`                   // RECEIPT_API_STRICT_JSON
+	MaxBodyBytes        int  `json:"maxBodyBytes" yaml:"maxBodyBytes"`               // RECEIPT_API_MAX_BODY_BYTES
+
+	RequestTimeout string `json:"requestTimeout" yaml:"requestTimeout"` // RECEIPT_API_REQUEST_TIMEOUT, a duration such as "5s"
+
+	Token       string  `json:"token" yaml:"token"`             // RECEIPT_API_TOKEN
+	CORSOrigins string  `json:"corsOrigins" yaml:"corsOrigins"` // RECEIPT_API_CORS_ORIGINS
+	RateLimit   float64 `json:"rateLimit" yaml:"rateLimit"`     // RECEIPT_API_RATE_LIMIT
+	RateBurst   int     `json:"rateBurst" yaml:"rateBurst"`     // RECEIPT_API_RATE_BURST
+
+	TrustedProxies string `json:"trustedProxies" yaml:"trustedProxies"` // RECEIPT_API_TRUSTED_PROXIES
+
+	AllowReset  bool `json:"allowReset" yaml:"allowReset"`   // RECEIPT_API_ALLOW_RESET
+	AllowReload bool `json:"allowReload" yaml:"allowReload"` // RECEIPT_API_ALLOW_RELOAD
+	Admin       bool `json:"admin" yaml:"admin"`             // RECEIPT_API_ADMIN
+
+	LogFormat string `json:"logFormat" yaml:"logFormat"` // LOG_FORMAT
+}
+
+// defaultConfig returns the settings used when neither the config file nor
+// the environment sets them.
+func defaultConfig() Config {
+	return Config{
+		Addr:           defaultAddr,
+		Store:          StoreMemory,
+		StoreShards:    1,
+		Deduplicate:    true,
+		MaxItems:       scoring.DefaultMaxItems,
+		MaxPoints:      scoring.DefaultMaxPoints,
+		MaxTotalCents:  scoring.DefaultMaxTotalCents,
+		MaxBodyBytes:   defaultMaxBodyBytes,
+		RequestTimeout: defaultRequestTimeout.String(),
+		CORSOrigins:    defaultCORSOrigins,
+		RateBurst:      defaultRateLimitBurst,
+		LogFormat:      logFormatText,
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults, then
+// applies the environment. Files ending in .yaml or .yml are parsed as YAML,
+// anything else as JSON; unknown settings are rejected so typos don't go
+// unnoticed. An empty path skips the file.
+func loadConfig(path string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml":
+			decoder := yaml.NewDecoder(bytes.NewReader(data))
+			decoder.KnownFields(true)
+			err = decoder.Decode(&config)
+		default:
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&config)
+		}
+		if err != nil {
+			return Config{}, errors.New(path + ": " + err.Error())
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// applyEnv overrides each setting whose environment variable is set.
+func (c *Config) applyEnv() error {
+	var err error
+	setString := func(dst *string, name string) {
+		if value := os.Getenv(name); value != "" {
+			*dst = value
+		}
+	}
+	setBool := func(dst *bool, name string) {
+		if err == nil {
+			*dst, err = envBool(name, *dst)
+		}
+	}
+	setInt := func(dst *int, name string) {
+		if err == nil {
+			*dst, err = envInt(name, *dst)
+		}
+	}
+	setFloat := func(dst *float64, name string) {
+		if err == nil {
+			*dst, err = envFloat(name, *dst)
+		}
+	}
+
+	setString(&c.Addr, "RECEIPT_API_ADDR")
+	setString(&c.TLSCert, "RECEIPT_API_TLS_CERT")
+	setString(&c.TLSKey, "RECEIPT_API_TLS_KEY")
+	setString(&c.Store, "RECEIPT_API_STORE")
+	setString(&c.DataFile, "RECEIPT_API_DATA_FILE")
+	setInt(&c.StoreShards, "RECEIPT_API_STORE_SHARDS")
+	setInt(&c.StoreCapacity, "RECEIPT_API_STORE_CAPACITY")
+	setBool(&c.Deduplicate, "RECEIPT_API_DEDUPLICATE")
+	setString(&c.TTL, "RECEIPT_API_TTL")
+	setBool(&c.AsyncScoring, "RECEIPT_API_ASYNC_SCORING")
+	setString(&c.WebhookURL, "RECEIPT_API_WEBHOOK_URL")
+	setString(&c.RulesFile, "RECEIPT_API_RULES_FILE")
+
+	setInt(&c.MaxItems, "RECEIPT_API_MAX_ITEMS")
+	setInt(&c.MaxPoints, "RECEIPT_API_MAX_POINTS")
+	setInt(&c.MaxTotalCents, "RECEIPT_API_MAX_TOTAL_CENTS")
+	setBool(&c.CheckTotal, "RECEIPT_API_CHECK_TOTAL")
+	setInt(&c.TotalToleranceCents, "RECEIPT_API_TOTAL_TOLERANCE_CENTS")
+	setBool(&c.RoundTotal, "RECEIPT_API_ROUND_TOTAL")
+	setBool(&c.RejectFutureDates, "RECEIPT_API_REJECT_FUTURE_DATES")
+	setBool(&c.StrictJSON, "RECEIPT_API_STRICT_JSON")
+	setInt(&c.MaxBodyBytes, "RECEIPT_API_MAX_BODY_BYTES")
+	setString(&c.RequestTimeout, "RECEIPT_API_REQUEST_TIMEOUT")
+
+	setString(&c.Token, "RECEIPT_API_TOKEN")
+	setString(&c.CORSOrigins, "RECEIPT_API_CORS_ORIGINS")
+	setFloat(&c.RateLimit, "RECEIPT_API_RATE_LIMIT")
+	setInt(&c.RateBurst, "RECEIPT_API_RATE_BURST")
+	setString(&c.TrustedProxies, "RECEIPT_API_TRUSTED_PROXIES")
+
+	setBool(&c.AllowReset, "RECEIPT_API_ALLOW_RESET")
+	setBool(&c.AllowReload, "RECEIPT_API_ALLOW_RELOAD")
+	setBool(&c.Admin, "RECEIPT_API_ADMIN")
+
+	setString(&c.LogFormat, "LOG_FORMAT")
+	return err
+}
+
+// validate checks the values that came from the config file, which skip the
+// checks the environment variables get.
+func (c Config) validate() error {
+	for name, n := range map[string]int{
+		"storeShards":         c.StoreShards,
+		"storeCapacity":       c.StoreCapacity,
+		"maxItems":            c.MaxItems,
+		"maxPoints":           c.MaxPoints,
+		"maxTotalCents":       c.MaxTotalCents,
+		"totalToleranceCents": c.TotalToleranceCents,
+		"maxBodyBytes":        c.MaxBodyBytes,
+		"rateBurst":           c.RateBurst,
+	} {
+		if n < 0 {
+			return errors.New(name + " must be a non-negative integer")
+		}
+	}
+	if c.RateLimit < 0 || math.IsInf(c.RateLimit, 0) || math.IsNaN(c.RateLimit) {
+		return errors.New("rateLimit must be a non-negative number")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tlsCert (RECEIPT_API_TLS_CERT) and tlsKey (RECEIPT_API_TLS_KEY) must be set together")
+	}
+	if _, err := c.ttl(); err != nil {
+		return err
+	}
+	if _, err := c.requestTimeout(); err != nil {
+		return err
+	}
+	if _, err := c.trustedProxies(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ttl parses the TTL setting. Empty means receipts are kept forever.
+func (c Config) ttl() (time.Duration, error) {
+	if c.TTL == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.TTL)
+	if err != nil || d < 0 {
+		return 0, errors.New("ttl (RECEIPT_API_TTL) must be a non-negative duration such as \"24h\"")
+	}
+	return d, nil
+}
+
+// tls reports whether the server should serve HTTPS.
+func (c Config) tls() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// requestTimeout parses the RequestTimeout setting. Empty or zero means
+// requests have no deadline.
+func (c Config) requestTimeout() (time.Duration, error) {
+	if c.RequestTimeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.RequestTimeout)
+	if err != nil || d < 0 {
+		return 0, errors.New("requestTimeout (RECEIPT_API_REQUEST_TIMEOUT) must be a non-negative duration such as \"5s\"")
+	}
+	return d, nil
+}
+
+// trustedProxies parses the TrustedProxies setting, a comma-separated list of
+// IP addresses and CIDR ranges whose X-Forwarded-For headers are believed.
+// Empty trusts no proxy, so clients are identified by their own address.
+func (c Config) trustedProxies() ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, errors.New("trustedProxies (RECEIPT_API_TRUSTED_PROXIES) must list IP addresses or CIDR ranges")
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// limits returns the receipt limits the config sets.
+func (c Config) limits() scoring.Limits {
+	return scoring.Limits{
+		MaxItems:            c.MaxItems,
+		MaxPoints:           c.MaxPoints,
+		MaxTotalCents:       int64(c.MaxTotalCents),
+		CheckItemTotal:      c.CheckTotal,
+		TotalToleranceCents: int64(c.TotalToleranceCents),
+		RoundTotal:          c.RoundTotal,
+		RejectFutureDates:   c.RejectFutureDates,
+	}
+}
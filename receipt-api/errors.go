@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Machine-readable error codes returned in the "code" field of every error
+// response, so clients can branch on them instead of the message text.
+const (
+	CodeInvalidJSON          = "INVALID_JSON"
+	CodeValidationFailed     = "VALIDATION_FAILED"
+	CodeUnknownField         = "UNKNOWN_FIELD"
+	CodeReceiptNotFound      = "RECEIPT_NOT_FOUND"
+	CodeReceiptExpired       = "RECEIPT_EXPIRED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeInvalidParameter     = "INVALID_PARAMETER"
+	CodeInvalidID            = "INVALID_ID"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeInvalidEncoding      = "INVALID_ENCODING"
+	CodeBodyTooLarge         = "BODY_TOO_LARGE"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeOriginNotAllowed     = "ORIGIN_NOT_ALLOWED"
+	CodeTimeout              = "TIMEOUT"
+	CodeNotPersisted         = "NOT_PERSISTED"
+	CodeInternal             = "INTERNAL_ERROR"
+)
+
+// errorBody builds the error object every error response carries: a
+// machine-readable code and a human-readable message under "error".
+// Validation failures add the offending "field" or "fields", and batch
+// results their "status".
+func errorBody(code, message string) gin.H {
+	return gin.H{"error": message, "code": code}
+}
+
+// unknownFieldBody reports a field that strict decoding doesn't recognize.
+func unknownFieldBody(field string) gin.H {
+	body := errorBody(CodeUnknownField, "Unknown field "+strconv.Quote(field))
+	body["field"] = field
+	return body
+}
+
+// invalidFieldsBody reports a receipt that failed validation, listing every
+// offending field.
+func invalidFieldsBody(fields []gin.H) gin.H {
+	body := errorBody(CodeValidationFailed, "Invalid receipt")
+	body["fields"] = fields
+	return body
+}
+
+// withStatus records the HTTP status a batch or import entry would have had
+// on its own in its result.
+func withStatus(result gin.H, status int) gin.H {
+	result["status"] = status
+	return result
+}
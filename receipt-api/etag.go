@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// pointsETag returns the entity tag for a receipt's points in the negotiated
+// format, a MIME type. Points only change when a receipt is rescored, so the
+// ID, points and format identify the response. The tag is weak because the
+// body's bytes vary with ?pretty and compression.
+func pointsETag(id string, points int, format string) string {
+	sum := sha256.Sum256([]byte(id + ":" + strconv.Itoa(points) + ":" + format))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
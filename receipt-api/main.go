@@ -1,137 +1,444 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
-)
 
-type Receipt struct {
-	Retailer     string `json:"retailer"`
-	PurchaseDate string `json:"purchaseDate"`
-	PurchaseTime string `json:"purchaseTime"`
-	Items        []Item `json:"items"`
-	Total        string `json:"total"`
-}
+	"receipt-api/scoring"
+)
 
-type Item struct {
-	ShortDescription string `json:"shortDescription"`
-	Price            string `json:"price"`
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(name + " must be a boolean")
+	}
+	return b, nil
 }
 
-type ReceiptStore struct {
-	mu       sync.Mutex
-	receipts map[string]int
+func envFloat(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, errors.New(name + " must be a non-negative number")
+	}
+	return f, nil
 }
 
-func NewReceiptStore() *ReceiptStore {
-	return &ReceiptStore{
-		receipts: make(map[string]int),
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " must be a non-negative integer")
 	}
+	return n, nil
 }
 
-func (s *ReceiptStore) AddReceipt(receipt Receipt) string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// mimeNDJSON is the content type of newline-delimited JSON.
+const mimeNDJSON = "application/x-ndjson"
 
-	// Generate unique ID
-	id := uuid.New().String()
+// requireJSON rejects requests whose body is not declared as JSON with 415
+// Unsupported Media Type.
+func requireJSON() gin.HandlerFunc {
+	return requireContentType(gin.MIMEJSON)
+}
 
-	// Calculate points
-	points := calculatePoints(receipt)
+// requireContentType rejects requests whose body is not declared as mimeType
+// with 415 Unsupported Media Type.
+func requireContentType(mimeType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.ContentType() != mimeType {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, errorBody(CodeUnsupportedMediaType, "Content-Type must be "+mimeType))
+			return
+		}
+		c.Next()
+	}
+}
 
-	// Store receipt and points
-	s.receipts[id] = points
+// deprecatedAlias marks responses from unversioned routes as deprecated,
+// pointing clients at the same path under prefix.
+func deprecatedAlias(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+prefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
 
-	return id
+// limitBody caps the request body at maxBytes; reading past it fails with an
+// *http.MaxBytesError. A limit of zero disables the cap.
+func limitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		c.Next()
+	}
 }
 
-func (s *ReceiptStore) GetPoints(id string) (int, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// unknownFieldError reports a JSON field that scoring.Receipt doesn't define,
+// found while decoding in strict mode.
+type unknownFieldError struct {
+	Field string
+}
 
-	points, exists := s.receipts[id]
-	return points, exists
+func (e *unknownFieldError) Error() string {
+	return "unknown field " + strconv.Quote(e.Field)
 }
 
-func calculatePoints(receipt Receipt) int {
-	points := 0
+// decodeReceipt decodes a receipt from r and checks its binding tags. In
+// strict mode, fields the receipt doesn't define are rejected with an
+// *unknownFieldError instead of being ignored.
+func decodeReceipt(r io.Reader, strict bool) (scoring.Receipt, error) {
+	var receipt scoring.Receipt
+	decoder := json.NewDecoder(r)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&receipt); err != nil {
+		// encoding/json has no error type for unknown fields, only the message.
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if field, err := strconv.Unquote(name); err == nil {
+				return scoring.Receipt{}, &unknownFieldError{Field: field}
+			}
+		}
+		return scoring.Receipt{}, err
+	}
+	return receipt, binding.Validator.ValidateStruct(&receipt)
+}
 
-	// Rule 1: One point for every alphanumeric character in the retailer name
-	for _, char := range receipt.Retailer {
-		if (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
-			points++
+// bindReceipt decodes, normalizes and validates the receipt in the request
+// body. On failure it writes the error response and returns false.
+func bindReceipt(c *gin.Context, limits scoring.Limits, strict bool) (scoring.Receipt, bool) {
+	receipt, err := decodeReceipt(c.Request.Body, strict)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		var unknown *unknownFieldError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, errorBody(CodeBodyTooLarge, "Request body too large"))
+		} else if errors.As(err, &unknown) {
+			c.JSON(http.StatusBadRequest, unknownFieldBody(unknown.Field))
+		} else if fields, ok := bindingErrorFields(err); ok {
+			validationFailures.Inc()
+			c.JSON(http.StatusUnprocessableEntity, invalidFieldsBody(fields))
+		} else {
+			c.JSON(http.StatusBadRequest, errorBody(CodeInvalidJSON, "Invalid JSON"))
 		}
+		return scoring.Receipt{}, false
 	}
 
-	// Rule 2: 50 points if the total is a round dollar amount with no cents
-	if receipt.Total[len(receipt.Total)-3:] == ".00" {
-		points += 50
+	receipt = scoring.NormalizeTotal(scoring.Normalize(receipt), limits)
+	if err := scoring.Validate(receipt, limits); err != nil {
+		validationFailures.Inc()
+		c.JSON(http.StatusUnprocessableEntity, validationErrorBody(err))
+		return scoring.Receipt{}, false
 	}
+	return receipt, true
+}
 
-	// Rule 3: 25 points if the total is a multiple of 0.25
-	if totalFloat, err := strconv.ParseFloat(receipt.Total, 64); err == nil && int(totalFloat*100)%25 == 0 {
-		points += 25
+// receiptIDParam returns the receipt ID in the path. IDs are always UUIDs,
+// so anything else is a client mistake rather than a receipt that doesn't
+// exist; for those it writes a 400 response and returns false.
+func receiptIDParam(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidID, "Receipt ID must be a UUID"))
+		return "", false
 	}
+	return id, true
+}
 
-	// Rule 4: 5 points for every two items on the receipt
-	points += (len(receipt.Items) / 2) * 5
+// csvSafe keeps a client-supplied cell from being run as a formula when the
+// export is opened in a spreadsheet, by prefixing cells that start with a
+// formula character with a single quote.
+func csvSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
 
-	// Rule 5: If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up
-	for _, item := range receipt.Items {
-		trimmedLength := len(strings.TrimSpace(item.ShortDescription))
-		if trimmedLength%3 == 0 {
-			if price, err := strconv.ParseFloat(item.Price, 64); err == nil {
-				points += int(math.Ceil(price * 0.2))
+func init() {
+	// Report binding errors using the JSON field names clients send.
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
 			}
-		}
+			return name
+		})
 	}
+}
 
-	// Rule 6: 6 points if the purchase date is odd
-	if dateParts := strings.Split(receipt.PurchaseDate, "-"); len(dateParts) == 3 {
-		if day, err := strconv.Atoi(dateParts[2]); err == nil && day%2 == 1 {
-			points += 6
+var arrayIndexPattern = regexp.MustCompile(`\.(\d+)`)
+
+// bindingErrorFields translates JSON type mismatches and binding tag failures
+// into a list of the offending fields. It reports false for errors that
+// aren't about specific fields, such as malformed JSON.
+func bindingErrorFields(err error) ([]gin.H, bool) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// Field is e.g. "items.0.price"; match the validator's "items[0].price".
+		field := arrayIndexPattern.ReplaceAllString(typeErr.Field, "[$1]")
+		return []gin.H{{"field": field, "message": "must be a " + typeErr.Type.String()}}, true
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil, false
+	}
+
+	fields := make([]gin.H, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// Namespace is e.g. "Receipt.items[0].price"; drop the struct name.
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		message := "failed the " + fe.Tag() + " check"
+		switch fe.Tag() {
+		case "required":
+			message = "is required"
+		case "required_without":
+			message = "is required unless purchaseDateTime is given"
 		}
+		fields = append(fields, gin.H{"field": field, "message": message})
 	}
+	return fields, true
+}
 
-	// Rule 7: 10 points if the purchase time is between 2:00 PM and 4:00 PM
-	if timeParts := strings.Split(receipt.PurchaseTime, ":"); len(timeParts) == 2 {
-		if hour, err := strconv.Atoi(timeParts[0]); err == nil && hour >= 14 && hour < 16 {
-			points += 10
+// validationErrorBody builds the JSON error body for a failed validation,
+// listing every offending field in the same shape as binding errors.
+func validationErrorBody(err error) gin.H {
+	var validationErrs scoring.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]gin.H, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, gin.H{"field": fe.Field, "message": fe.Message})
 		}
+		return invalidFieldsBody(fields)
 	}
+	return errorBody(CodeValidationFailed, err.Error())
+}
 
-	return points
+// batchBonusHeader carries a batch's cross-shopping bonus.
+const batchBonusHeader = "X-Batch-Bonus"
+
+// respondBatch answers a batch with its results, one per receipt in input
+// order. The batch's cross-shopping bonus, zero when the rule is off, goes in
+// a header so the body stays an array whatever the rules, which can change
+// on reload.
+func respondBatch(c *gin.Context, results []gin.H, bonus int) {
+	c.Header(batchBonusHeader, strconv.Itoa(bonus))
+	c.JSON(http.StatusOK, results)
 }
 
+// respondJSON writes obj as indented JSON when the request has ?pretty=true
+// and as compact JSON otherwise.
+func respondJSON(c *gin.Context, status int, obj any) {
+	if pretty, _ := strconv.ParseBool(c.Query("pretty")); pretty {
+		c.IndentedJSON(status, obj)
+		return
+	}
+	c.JSON(status, obj)
+}
+
+const (
+	defaultAddr     = ":8080"
+	shutdownTimeout = 10 * time.Second
+
+	defaultCORSOrigins = "*"
+
+	defaultRateLimitBurst = 20
+
+	defaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+	defaultRequestTimeout = 5 * time.Second
+
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
 func main() {
-	receiptStore := NewReceiptStore()
-	r := gin.Default()
+	configFile := flag.String("config", "", "YAML or JSON file with the server settings; environment variables override it")
+	addr := flag.String("addr", "", "address to listen on, e.g. \":8080\" or \"127.0.0.1:9000\"; overrides the config")
+	flag.Parse()
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if *addr != "" {
+		config.Addr = *addr
+	}
+	limits := config.limits()
+	// validate has already checked the TTL.
+	ttl, _ := config.ttl()
+
+	rules, err := scoring.LoadRulesConfig(config.RulesFile)
+	if err != nil {
+		log.Fatalf("failed to load rules config: %v", err)
+	}
+
+	logger, err := newLogger(config.LogFormat)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	// The stores log through the default logger, so they use the format too.
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	storeOpts := StoreOptions{
+		Path:         config.DataFile,
+		MaxPoints:    limits.MaxPoints,
+		Deduplicate:  config.Deduplicate,
+		Rules:        rules,
+		TTL:          ttl,
+		AsyncScoring: config.AsyncScoring,
+		Capacity:     config.StoreCapacity,
+		Shards:       config.StoreShards,
+	}
+	var hook *webhook
+	if config.WebhookURL != "" {
+		hook = newWebhook(config.WebhookURL, logger)
+		storeOpts.OnAdd = hook.notify
+	}
+
+	receiptStore, err := OpenStore(config.Store, storeOpts)
+	if err != nil {
+		log.Fatalf("failed to load receipt store: %v", err)
+	}
+
+	// ready reports whether the service can take traffic: the store has been
+	// loaded, the server is listening and it is not shutting down.
+	var ready atomic.Bool
 
-	r.POST("/receipts/process", func(c *gin.Context) {
-		var receipt Receipt
-		if err := c.ShouldBindJSON(&receipt); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+	r, err := newRouter(receiptStore, config, logger, &ready)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var handler http.Handler = r
+	if timeout, _ := config.requestTimeout(); timeout > 0 {
+		handler = withRequestTimeout(r, timeout, timeoutExempt, logger)
+	}
+
+	server := &http.Server{
+		Addr:    config.Addr,
+		Handler: handler,
+		// Only used when serving HTTPS. TLS 1.0 and 1.1 are deprecated
+		// (RFC 8996), so clients need at least TLS 1.2.
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Bind before reporting ready, so a probe never sees the service ready
+	// while the address is unavailable.
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		closeStore(receiptStore, logger)
+		log.Fatalf("failed to listen on %s: %v", config.Addr, err)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("server listening", "addr", listener.Addr().String(), "tls", config.tls())
+		if config.tls() {
+			serverErr <- server.ServeTLS(listener, config.TLSCert, config.TLSKey)
 			return
 		}
+		serverErr <- server.Serve(listener)
+	}()
+	ready.Store(true)
+
+	// A failed server still shuts down in order, so no stored receipts are
+	// lost, and then exits non-zero.
+	var serveErr error
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+			logger.Error("server failed, shutting down", "error", err)
+		}
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, draining in-flight requests", "timeout", shutdownTimeout)
+	}
+	ready.Store(false)
 
-		id := receiptStore.AddReceipt(receipt)
-		c.JSON(http.StatusOK, gin.H{"id": id})
-	})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("server shutdown did not complete", "error", err)
+	}
 
-	r.GET("/receipts/:id/points", func(c *gin.Context) {
-		id := c.Param("id")
-		if points, exists := receiptStore.GetPoints(id); exists {
-			c.JSON(http.StatusOK, gin.H{"points": points})
-		} else {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+	closeStore(receiptStore, logger)
+	// The store no longer adds receipts, so the queued notifications are the
+	// last ones. They get their own timeout, since draining requests may have
+	// used up the shutdown one.
+	if hook != nil {
+		logger.Info("delivering queued webhook notifications")
+		hookCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := hook.Close(hookCtx); err != nil {
+			logger.Error("webhook deliveries did not complete", "error", err)
 		}
-	})
+	}
+	// Like the webhook, tracing gets its own timeout.
+	tracingCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logger.Error("failed to flush traces", "error", err)
+	}
+	if serveErr != nil {
+		log.Fatalf("server failed: %v", serveErr)
+	}
+	logger.Info("shutdown complete")
+}
 
-	r.Run(":8080")
+// closeStore flushes the receipt store to disk, if it is persisted, and
+// closes it.
+func closeStore(receiptStore Store, logger *slog.Logger) {
+	logger.Info("flushing receipt store")
+	if _, err := receiptStore.Flush(); err != nil && !errors.Is(err, ErrNotPersisted) {
+		logger.Error("failed to flush receipt store", "error", err)
+	}
+	if err := receiptStore.Close(); err != nil {
+		logger.Error("failed to close receipt store", "error", err)
+	}
 }
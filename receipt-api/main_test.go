@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"receipt-api/scoring"
+)
+
+func TestReceiptIDParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tt := range []struct {
+		id    string
+		valid bool
+	}{
+		{"7fb1377b-b223-49d9-a31a-5a02701dd310", true},
+		{"not-a-uuid", false},
+		{"7fb1377b", false},
+		{"", false},
+	} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/receipts/x/points", nil)
+		c.Params = gin.Params{{Key: "id", Value: tt.id}}
+
+		id, ok := receiptIDParam(c)
+		if ok != tt.valid {
+			t.Errorf("%q: valid = %v, want %v", tt.id, ok, tt.valid)
+		}
+		if ok && id != tt.id {
+			t.Errorf("%q: returned %q", tt.id, id)
+		}
+		if !ok && (rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), CodeInvalidID)) {
+			t.Errorf("%q: got %d %s, want 400 %s", tt.id, rec.Code, rec.Body, CodeInvalidID)
+		}
+	}
+}
+
+// BenchmarkRetailerNameRule measures rule 1, counting the letters and digits
+// in the retailer name, with every other rule turned off.
+func BenchmarkRetailerNameRule(b *testing.B) {
+	rules := scoring.DefaultRulesConfig()
+	rules.DisabledRules = []string{
+		scoring.RuleRoundDollarTotal,
+		scoring.RuleQuarterTotal,
+		scoring.RuleItemPairs,
+		scoring.RuleItemDescription,
+		scoring.RuleOddPurchaseDay,
+		scoring.RuleAfternoonPurchase,
+	}
+
+	for _, bm := range []struct {
+		name     string
+		retailer string
+	}{
+		{"short", "M&M Corner Market"},
+		{"ascii", strings.Repeat("Walgreens #1234 ", 112) + "Pharmacy"},
+		{"unicode", strings.Repeat("Café Ω Ünïcödé ", 73) + "Markt"},
+	} {
+		receipt := testReceipt(bm.retailer)
+		b.Run(bm.name, func(b *testing.B) {
+			for range b.N {
+				scoring.Calculate(receipt, rules)
+			}
+		})
+	}
+}
+
+func TestRespondBatchKeepsArrayShape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, bonus := range []int{0, 25} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		respondBatch(c, []gin.H{{"status": http.StatusOK, "id": "a"}}, bonus)
+
+		var results []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || len(results) != 1 {
+			t.Errorf("bonus %d: body %s is not an array of one result", bonus, rec.Body)
+		}
+		if got := rec.Header().Get(batchBonusHeader); got != strconv.Itoa(bonus) {
+			t.Errorf("bonus %d: %s = %q", bonus, batchBonusHeader, got)
+		}
+	}
+}
+
+func TestBindReceiptRequiresItems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, items := range []string{`null`, `[]`} {
+		body := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","total":"1.00","items":` + items + `}`
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+
+		if _, ok := bindReceipt(c, scoring.DefaultLimits(), false); ok {
+			t.Errorf("items %s: receipt accepted", items)
+			continue
+		}
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "receipt must contain at least one item") {
+			t.Errorf("items %s: got %d %s", items, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	for _, tt := range []struct{ cell, want string }{
+		{"Target", "Target"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"M&M = Market", "M&M = Market"},
+	} {
+		if got := csvSafe(tt.cell); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func TestPointsETagDependsOnFormat(t *testing.T) {
+	const id = "7fb1377b-b223-49d9-a31a-5a02701dd310"
+	jsonTag, textTag := pointsETag(id, 28, gin.MIMEJSON), pointsETag(id, 28, gin.MIMEPlain)
+	if jsonTag == textTag {
+		t.Errorf("JSON and text share the ETag %s", jsonTag)
+	}
+	if etagMatches(jsonTag, textTag) {
+		t.Errorf("text ETag %s matches If-None-Match %s", textTag, jsonTag)
+	}
+	if jsonTag != pointsETag(id, 28, gin.MIMEJSON) {
+		t.Error("ETag is not stable")
+	}
+	if jsonTag == pointsETag(id, 29, gin.MIMEJSON) {
+		t.Error("ETag ignores the points")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	receiptsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "receipts_processed_total",
+		Help: "Number of receipts scored and stored.",
+	})
+
+	validationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "receipt_validation_failures_total",
+		Help: "Number of submitted receipts rejected by validation.",
+	})
+
+	pointsNotFound = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "receipt_points_not_found_total",
+		Help: "Number of points lookups for unknown receipt IDs.",
+	})
+
+	pointsAwarded = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "receipt_points_awarded",
+		Help:    "Distribution of points awarded per receipt.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	})
+
+	calculationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "receipt_points_calculation_seconds",
+		Help:    "Time spent scoring a receipt.",
+		Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+	})
+)
@@ -0,0 +1,11 @@
+package main
+
+import (
+	_ "embed"
+)
+
+// openAPISpec describes the HTTP API. Keep it in sync with the handlers and
+// the scoring.Receipt and scoring.Item structs.
+//
+//go:embed openapi.json
+var openAPISpec []byte
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long a client's bucket is kept after its last
+// request before it is discarded.
+const limiterIdleTimeout = 3 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter keeps a token bucket per client IP.
+type ipRateLimiter struct {
+	mu          sync.Mutex
+	clients     map[string]*clientLimiter
+	rps         rate.Limit
+	burst       int
+	lastCleanup time.Time
+}
+
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		clients:     make(map[string]*clientLimiter),
+		rps:         rate.Limit(rps),
+		burst:       max(burst, 1),
+		lastCleanup: time.Now(),
+	}
+}
+
+// reserve takes a token from the client's bucket. It returns zero if the
+// request may proceed, or how long the client should wait otherwise.
+func (l *ipRateLimiter) reserve(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > limiterIdleTimeout {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > limiterIdleTimeout {
+				delete(l.clients, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	client, exists := l.clients[ip]
+	if !exists {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	reservation := client.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return limiterIdleTimeout
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// rateLimit rejects requests from clients that exceed their rate with 429
+// Too Many Requests and a Retry-After header.
+func rateLimit(limiter *ipRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if delay := limiter.reserve(c.ClientIP()); delay > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorBody(CodeRateLimited, "Rate limit exceeded"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(rateLimit(newIPRateLimiter(1, 1)))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var statuses []int
+	for i := range 4 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		statuses = append(statuses, rec.Code)
+	}
+
+	want := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"10.0.0.0/8, 192.0.2.1", 2, false},
+		{"::1", 1, false},
+		{"proxy.internal", 0, true},
+	} {
+		proxies, err := Config{TrustedProxies: tt.value}.trustedProxies()
+		if (err != nil) != tt.wantErr || len(proxies) != tt.want {
+			t.Errorf("trustedProxies(%q) = %v, %v; want %d proxies, error %v", tt.value, proxies, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recovery turns a panic in a handler into a JSON 500 response. The panic and
+// its stack trace go to the request's log, tagged with its request ID, and
+// never to the client.
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		requestLog(c).Error("panic recovered",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"error", err,
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, errorBody(CodeInternal, "Internal server error"))
+	})
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"receipt-api/scoring"
+)
+
+// newRouter builds the engine serving every endpoint from receiptStore with
+// the given settings. The /ready probe answers from ready, which the caller
+// sets once the server is listening.
+func newRouter(receiptStore Store, config Config, logger *slog.Logger, ready *atomic.Bool) (*gin.Engine, error) {
+	limits := config.limits()
+
+	// With RECEIPT_API_TOKEN set, everything but the probes needs the token.
+	authenticate := requireToken(config.Token)
+
+	r := gin.New()
+	// The client IP, which rate limiting is keyed on, only comes from
+	// X-Forwarded-For when the request arrives through a trusted proxy;
+	// otherwise any client could pick its own. validate has already checked
+	// the list.
+	proxies, _ := config.trustedProxies()
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return nil, err
+	}
+
+	// Probes and other operational endpoints are registered before the
+	// middleware so they stay cheap and don't flood the request log.
+	r.GET("/health", func(c *gin.Context) {
+		respondJSON(c, http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	r.GET("/ready", func(c *gin.Context) {
+		if !ready.Load() {
+			respondJSON(c, http.StatusServiceUnavailable, gin.H{"status": "not ready"})
+			return
+		}
+		respondJSON(c, http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	r.GET("/metrics", authenticate, gin.WrapH(promhttp.Handler()))
+
+	r.GET("/version", authenticate, func(c *gin.Context) {
+		respondJSON(c, http.StatusOK, gin.H{"version": version, "commit": commit, "buildTime": buildTime})
+	})
+
+	r.Use(tracing(), requestLogger(logger), recovery(), cors(parseOrigins(config.CORSOrigins)))
+	if config.RateLimit > 0 {
+		r.Use(rateLimit(newIPRateLimiter(config.RateLimit, config.RateBurst)))
+	}
+	r.Use(authenticate, gzipCompression())
+
+	r.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, errorBody(CodeNotFound, "Not found"))
+	})
+
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openAPISpec)
+	})
+
+	// registerReceiptRoutes adds the receipt endpoints to g. They are served
+	// under /v1 and, for existing clients, as deprecated unversioned aliases.
+	// receiptMissing answers a request for a receipt that isn't stored: 410
+	// if it expired recently, so clients know not to retry, and 404 if the
+	// ID was never issued or expired too long ago to tell.
+	receiptMissing := func(c *gin.Context, id string) {
+		if expired, err := receiptStore.Expired(id); err == nil && expired {
+			respondJSON(c, http.StatusGone, errorBody(CodeReceiptExpired, "Receipt has expired"))
+			return
+		}
+		respondJSON(c, http.StatusNotFound, errorBody(CodeReceiptNotFound, "Receipt not found"))
+	}
+
+	registerReceiptRoutes := func(g *gin.RouterGroup) {
+		// processEntry stores one receipt of a batch or import and returns its
+		// result, carrying the HTTP status the receipt would have received on
+		// its own, and the retailer if it was stored. logAttrs locate the
+		// entry in the request's log lines.
+		processEntry := func(c *gin.Context, raw []byte, logAttrs ...any) (gin.H, string) {
+			receipt, err := decodeReceipt(bytes.NewReader(raw), config.StrictJSON)
+			if err != nil {
+				var unknown *unknownFieldError
+				if errors.As(err, &unknown) {
+					return withStatus(unknownFieldBody(unknown.Field), http.StatusBadRequest), ""
+				}
+				if fields, ok := bindingErrorFields(err); ok {
+					validationFailures.Inc()
+					return withStatus(invalidFieldsBody(fields), http.StatusUnprocessableEntity), ""
+				}
+				return withStatus(errorBody(CodeInvalidJSON, "Invalid JSON"), http.StatusBadRequest), ""
+			}
+
+			receipt = scoring.NormalizeTotal(scoring.Normalize(receipt), limits)
+			if err := scoring.Validate(receipt, limits); err != nil {
+				validationFailures.Inc()
+				return withStatus(validationErrorBody(err), http.StatusUnprocessableEntity), ""
+			}
+
+			id, points, err := receiptStore.AddReceipt(c.Request.Context(), receipt, "")
+			pending := errors.Is(err, ErrPending)
+			if err != nil && !pending {
+				status, body := storeFailure(err, "Failed to store receipt")
+				return withStatus(body, status), ""
+			}
+			if config.AsyncScoring || pending {
+				requestLog(c).Info("receipt queued", append([]any{"receiptId", id, "retailer", receipt.Retailer, "items", len(receipt.Items)}, logAttrs...)...)
+				return gin.H{"status": http.StatusAccepted, "id": id}, receipt.Retailer
+			}
+			requestLog(c).Info("receipt processed", append([]any{"receiptId", id, "retailer", receipt.Retailer, "items", len(receipt.Items), "points", points}, logAttrs...)...)
+			return gin.H{"status": http.StatusOK, "id": id, "points": points}, receipt.Retailer
+		}
+
+		g.POST("/receipts/process", requireJSON(), limitBody(int64(config.MaxBodyBytes)), func(c *gin.Context) {
+			receipt, ok := bindReceipt(c, limits, config.StrictJSON)
+			if !ok {
+				return
+			}
+
+			// A replay or duplicate of a receipt still being scored is pending
+			// too, rather than worth zero points.
+			id, points, err := receiptStore.AddReceipt(c.Request.Context(), receipt, c.GetHeader("Idempotency-Key"))
+			pending := errors.Is(err, ErrPending)
+			if err != nil && !pending {
+				c.JSON(storeFailure(err, "Failed to store receipt"))
+				return
+			}
+
+			c.Header("Location", strings.TrimSuffix(g.BasePath(), "/")+"/receipts/"+id+"/points")
+			if config.AsyncScoring || pending {
+				requestLog(c).Info("receipt queued", "receiptId", id, "retailer", receipt.Retailer, "items", len(receipt.Items))
+				c.JSON(http.StatusAccepted, gin.H{"id": id, "status": "pending"})
+				return
+			}
+
+			requestLog(c).Info("receipt processed", "receiptId", id, "retailer", receipt.Retailer, "items", len(receipt.Items), "points", points)
+			traceReceipt(c, id, points)
+			if includePoints, _ := strconv.ParseBool(c.Query("includePoints")); includePoints {
+				c.JSON(http.StatusCreated, gin.H{"id": id, "points": points})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{"id": id})
+		})
+
+		g.POST("/receipts/score", requireJSON(), limitBody(int64(config.MaxBodyBytes)), func(c *gin.Context) {
+			receipt, ok := bindReceipt(c, limits, config.StrictJSON)
+			if !ok {
+				return
+			}
+
+			points := receiptStore.Score(c.Request.Context(), receipt).Total
+			requestLog(c).Info("receipt scored", "retailer", receipt.Retailer, "items", len(receipt.Items), "points", points)
+			c.JSON(http.StatusOK, gin.H{"points": points})
+		})
+
+		// processBatch stores each receipt of a batch independently, so one bad
+		// entry does not fail the whole batch, and responds with the results in
+		// input order.
+		processBatch := func(c *gin.Context, batch []json.RawMessage) {
+			results := make([]gin.H, len(batch))
+			var retailers []string
+			for i, raw := range batch {
+				result, retailer := processEntry(c, raw, "batchIndex", i)
+				results[i] = result
+				if retailer != "" {
+					retailers = append(retailers, retailer)
+				}
+			}
+
+			respondBatch(c, results, scoring.BatchBonus(retailers, receiptStore.Rules()))
+		}
+
+		g.POST("/receipts/process/batch", requireJSON(), limitBody(int64(config.MaxBodyBytes)), func(c *gin.Context) {
+			var batch []json.RawMessage
+			if err := c.ShouldBindJSON(&batch); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					c.JSON(http.StatusRequestEntityTooLarge, errorBody(CodeBodyTooLarge, "Request body too large"))
+					return
+				}
+				c.JSON(http.StatusBadRequest, errorBody(CodeInvalidJSON, "Invalid JSON: expected an array of receipts"))
+				return
+			}
+			processBatch(c, batch)
+		})
+
+		// Uploads take a JSON file holding one receipt or an array of them,
+		// for browser forms, and process it like a batch.
+		g.POST("/receipts/upload", requireContentType(gin.MIMEMultipartPOSTForm), limitBody(int64(config.MaxBodyBytes)), func(c *gin.Context) {
+			header, err := c.FormFile("file")
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					c.JSON(http.StatusRequestEntityTooLarge, errorBody(CodeBodyTooLarge, "Request body too large"))
+					return
+				}
+				c.JSON(http.StatusBadRequest, errorBody(CodeInvalidParameter, "Missing file field"))
+				return
+			}
+			file, err := header.Open()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to read upload"))
+				return
+			}
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to read upload"))
+				return
+			}
+
+			batch := []json.RawMessage{data}
+			if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+				if err := json.Unmarshal(trimmed, &batch); err != nil {
+					c.JSON(http.StatusBadRequest, errorBody(CodeInvalidJSON, "Invalid JSON: expected a receipt or an array of receipts"))
+					return
+				}
+			}
+			requestLog(c).Info("receipt file uploaded", "filename", header.Filename, "receipts", len(batch))
+			processBatch(c, batch)
+		})
+
+		// The import streams: each line is processed and its result written
+		// and flushed before the next is read, so the body is never held in
+		// memory. The body size limit applies to each line rather than the
+		// whole stream.
+		g.POST("/receipts/import", requireContentType(mimeNDJSON), func(c *gin.Context) {
+			lineLimit := config.MaxBodyBytes
+			if lineLimit == 0 {
+				lineLimit = math.MaxInt
+			}
+			scanner := bufio.NewScanner(c.Request.Body)
+			scanner.Buffer(nil, lineLimit)
+
+			// Go's HTTP/1 server otherwise stops the body from being read once
+			// the first result has been written.
+			if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+				requestLog(c).Warn("full duplex unavailable; large imports may be cut short", "error", err)
+			}
+
+			c.Header("Content-Type", mimeNDJSON)
+			c.Status(http.StatusOK)
+			encoder := json.NewEncoder(c.Writer)
+
+			lines, imported := 0, 0
+			for scanner.Scan() {
+				lines++
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+
+				result, _ := processEntry(c, line, "line", lines)
+				result["line"] = lines
+				if result["status"] == http.StatusOK || result["status"] == http.StatusAccepted {
+					imported++
+				}
+				if err := encoder.Encode(result); err != nil {
+					requestLog(c).Error("receipt import aborted", "line", lines, "error", err)
+					return
+				}
+				c.Writer.Flush()
+			}
+
+			// Once results have been streamed the status can't change, so a
+			// read failure is reported as a final result line.
+			if err := scanner.Err(); err != nil {
+				result := withStatus(errorBody(CodeInvalidJSON, "Failed to read request body"), http.StatusBadRequest)
+				if errors.Is(err, bufio.ErrTooLong) {
+					result = withStatus(errorBody(CodeBodyTooLarge, "Line too long"), http.StatusRequestEntityTooLarge)
+				}
+				result["line"] = lines + 1
+				encoder.Encode(result)
+				requestLog(c).Warn("receipt import stopped early", "lines", lines, "imported", imported, "error", err)
+				return
+			}
+			requestLog(c).Info("receipts imported", "lines", lines, "imported", imported)
+		})
+
+		if config.AllowReset {
+			g.POST("/receipts/reset", func(c *gin.Context) {
+				removed, err := receiptStore.Clear()
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to reset store"))
+					return
+				}
+				requestLog(c).Warn("receipt store reset", "removed", removed)
+				c.JSON(http.StatusOK, gin.H{"removed": removed})
+			})
+		}
+
+		g.GET("/receipts", func(c *gin.Context) {
+			limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultListLimit)))
+			if err != nil || limit < 1 || limit > maxListLimit {
+				respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidParameter, "limit must be an integer between 1 and "+strconv.Itoa(maxListLimit)))
+				return
+			}
+			offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+			if err != nil || offset < 0 {
+				respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidParameter, "offset must be a non-negative integer"))
+				return
+			}
+
+			// Each tag=key:value parameter narrows the list to receipts
+			// carrying that tag.
+			var filter ReceiptFilter
+			for _, tag := range c.QueryArray("tag") {
+				key, value, ok := strings.Cut(tag, ":")
+				if !ok || key == "" {
+					respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidParameter, "tag must be in key:value format"))
+					return
+				}
+				if filter.Tags == nil {
+					filter.Tags = make(map[string]string)
+				}
+				filter.Tags[key] = value
+			}
+			// from and to bound the purchase date, inclusive.
+			for _, bound := range []struct {
+				name string
+				dst  *time.Time
+			}{{"from", &filter.From}, {"to", &filter.To}} {
+				value, ok := c.GetQuery(bound.name)
+				if !ok {
+					continue
+				}
+				date, err := time.Parse(time.DateOnly, value)
+				if err != nil {
+					respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidParameter, bound.name+" must be a date in YYYY-MM-DD format"))
+					return
+				}
+				*bound.dst = date
+			}
+			if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+				respondJSON(c, http.StatusBadRequest, errorBody(CodeInvalidParameter, "from must not be after to"))
+				return
+			}
+
+			receipts, total, err := receiptStore.ListReceipts(limit, offset, filter)
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to list receipts"))
+				return
+			}
+			respondJSON(c, http.StatusOK, gin.H{"receipts": receipts, "total": total, "limit": limit, "offset": offset})
+		})
+
+		g.GET("/receipts/export.csv", func(c *gin.Context) {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", `attachment; filename="receipts.csv"`)
+			c.Status(http.StatusOK)
+
+			// Rows are written straight to the response as the store is walked. Once
+			// the first row is out the status can't change, so a failure part way
+			// through can only be logged and the body cut short.
+			w := csv.NewWriter(c.Writer)
+			w.Write([]string{"id", "retailer", "purchaseDate", "purchaseTime", "total", "itemCount", "points"})
+			rows := 0
+			err := receiptStore.WalkReceipts(func(id string, receipt scoring.Receipt, points int) error {
+				w.Write([]string{
+					id,
+					csvSafe(receipt.Retailer),
+					receipt.PurchaseDate,
+					receipt.PurchaseTime,
+					receipt.Total,
+					strconv.Itoa(len(receipt.Items)),
+					strconv.Itoa(points),
+				})
+				if rows++; rows%100 == 0 {
+					w.Flush()
+					c.Writer.Flush()
+				}
+				return w.Error()
+			})
+			w.Flush()
+			if err == nil {
+				err = w.Error()
+			}
+			if err != nil {
+				requestLog(c).Error("receipt export failed", "rows", rows, "error", err)
+				return
+			}
+			requestLog(c).Info("receipts exported", "rows", rows)
+		})
+
+		g.GET("/receipts/stats", func(c *gin.Context) {
+			stats, err := receiptStore.Stats()
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to compute statistics"))
+				return
+			}
+			respondJSON(c, http.StatusOK, stats)
+		})
+
+		g.GET("/receipts/summary/by-retailer", func(c *gin.Context) {
+			summary, err := receiptStore.SummaryByRetailer()
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to compute summary"))
+				return
+			}
+			respondJSON(c, http.StatusOK, summary)
+		})
+
+		// pointsHandler also answers HEAD, for monitoring tools checking that
+		// a receipt exists; the server drops the body.
+		pointsHandler := func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			score, exists, err := receiptStore.GetScore(id)
+			requestLog(c).Info("points lookup", "receiptId", id, "found", exists)
+			if errors.Is(err, ErrPending) {
+				respondJSON(c, http.StatusAccepted, gin.H{"status": "processing"})
+				return
+			}
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipt"))
+				return
+			}
+			if exists {
+				points := score.Points
+				traceReceipt(c, id, points)
+
+				// The verbose form says when the points were computed, for
+				// checking whether they reflect the current rules. It
+				// changes on every rescore, so it isn't given an ETag.
+				if verbose, _ := strconv.ParseBool(c.Query("verbose")); verbose {
+					respondJSON(c, http.StatusOK, score)
+					return
+				}
+
+				// Points can change on rescore, so clients may cache them but
+				// must revalidate with the ETag each time. Scripts can ask for
+				// the bare number with Accept: text/plain; that is a different
+				// representation, so it gets its own ETag, and a 304 carries
+				// Vary: Accept like the full response.
+				format := c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain)
+				etag := pointsETag(id, points, format)
+				c.Header("ETag", etag)
+				c.Header("Cache-Control", "private, no-cache")
+				c.Writer.Header().Add("Vary", "Accept")
+				if etagMatches(c.GetHeader("If-None-Match"), etag) {
+					c.Status(http.StatusNotModified)
+					return
+				}
+				if format == gin.MIMEPlain {
+					c.String(http.StatusOK, "%d\n", points)
+					return
+				}
+				respondJSON(c, http.StatusOK, gin.H{"points": points})
+			} else {
+				pointsNotFound.Inc()
+				receiptMissing(c, id)
+			}
+		}
+		g.GET("/receipts/:id/points", pointsHandler)
+		g.HEAD("/receipts/:id/points", pointsHandler)
+
+		g.GET("/receipts/by-key/:key/points", func(c *gin.Context) {
+			key := c.Param("key")
+			id, exists, err := receiptStore.LookupKey(key)
+			var points int
+			if exists && err == nil {
+				points, exists, err = receiptStore.GetPoints(id)
+			}
+			requestLog(c).Info("points lookup by key", "receiptId", id, "found", exists)
+			if errors.Is(err, ErrPending) {
+				respondJSON(c, http.StatusAccepted, gin.H{"id": id, "status": "processing"})
+				return
+			}
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipt"))
+				return
+			}
+			if !exists {
+				respondJSON(c, http.StatusNotFound, errorBody(CodeReceiptNotFound, "Receipt not found"))
+				return
+			}
+			respondJSON(c, http.StatusOK, gin.H{"id": id, "points": points})
+		})
+
+		g.GET("/receipts/:id/points/breakdown", func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			breakdown, exists, err := receiptStore.GetBreakdown(id)
+			requestLog(c).Info("breakdown lookup", "receiptId", id, "found", exists)
+			if errors.Is(err, ErrPending) {
+				respondJSON(c, http.StatusAccepted, gin.H{"status": "processing"})
+				return
+			}
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipt"))
+				return
+			}
+			if exists {
+				respondJSON(c, http.StatusOK, breakdown)
+			} else {
+				receiptMissing(c, id)
+			}
+		})
+
+		g.GET("/receipts/:id", func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			receipt, exists, err := receiptStore.GetReceipt(id)
+			requestLog(c).Info("receipt lookup", "receiptId", id, "found", exists)
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipt"))
+				return
+			}
+			if exists {
+				respondJSON(c, http.StatusOK, receipt)
+			} else {
+				receiptMissing(c, id)
+			}
+		})
+
+		// Existence is always answered with 200, so a false is never
+		// confused with a missing route or a proxy's 404.
+		g.GET("/receipts/:id/exists", func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			exists, err := receiptStore.Exists(id)
+			requestLog(c).Info("receipt existence check", "receiptId", id, "found", exists)
+			if err != nil {
+				respondJSON(c, http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipt"))
+				return
+			}
+			respondJSON(c, http.StatusOK, gin.H{"exists": exists})
+		})
+
+		g.POST("/receipts/:id/rescore", func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			points, exists, err := receiptStore.Rescore(c.Request.Context(), id)
+			requestLog(c).Info("receipt rescore", "receiptId", id, "found", exists, "points", points)
+			if err != nil {
+				c.JSON(storeFailure(err, "Failed to store receipt"))
+				return
+			}
+			if !exists {
+				receiptMissing(c, id)
+				return
+			}
+			traceReceipt(c, id, points)
+			c.JSON(http.StatusOK, gin.H{"id": id, "points": points})
+		})
+
+		g.DELETE("/receipts/:id", func(c *gin.Context) {
+			id, ok := receiptIDParam(c)
+			if !ok {
+				return
+			}
+			deleted, err := receiptStore.DeleteReceipt(id)
+			requestLog(c).Info("receipt delete", "receiptId", id, "found", deleted)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to delete receipt"))
+				return
+			}
+			if !deleted {
+				receiptMissing(c, id)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		})
+	}
+
+	registerReceiptRoutes(r.Group("/v1"))
+	registerReceiptRoutes(r.Group("", deprecatedAlias("/v1")))
+
+	admin := r.Group("/admin")
+	if config.Admin {
+		admin.GET("/rules", func(c *gin.Context) {
+			respondJSON(c, http.StatusOK, gin.H{"rulesFile": config.RulesFile, "rules": receiptStore.Rules()})
+		})
+
+		// Flushing writes the store to its data file now rather than at the
+		// next write or shutdown, e.g. before taking a backup. A store kept
+		// in memory only has no file to write, which is answered with 409.
+		admin.POST("/flush", func(c *gin.Context) {
+			persisted, err := receiptStore.Flush()
+			if errors.Is(err, ErrNotPersisted) {
+				c.JSON(http.StatusConflict, errorBody(CodeNotPersisted, "The receipt store has no data file to flush"))
+				return
+			}
+			if err != nil {
+				requestLog(c).Error("failed to flush receipt store", "error", err)
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to flush receipt store"))
+				return
+			}
+			path := config.DataFile
+			if config.Store == StoreSQLite && path == "" {
+				path = defaultSQLitePath
+			}
+			requestLog(c).Info("receipt store flushed", "path", path, "receipts", persisted)
+			respondJSON(c, http.StatusOK, gin.H{"path": path, "persisted": persisted})
+		})
+
+		// Simulation rescores every stored receipt with the proposed rules in
+		// the body, on top of the defaults like a rules file, and reports how
+		// the points would change. Nothing is stored.
+		admin.POST("/simulate", requireJSON(), limitBody(int64(config.MaxBodyBytes)), func(c *gin.Context) {
+			data, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					c.JSON(http.StatusRequestEntityTooLarge, errorBody(CodeBodyTooLarge, "Request body too large"))
+					return
+				}
+				c.JSON(http.StatusBadRequest, errorBody(CodeInvalidJSON, "Failed to read request body"))
+				return
+			}
+			proposed, err := scoring.ParseRulesConfig(data)
+			if err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					c.JSON(http.StatusBadRequest, errorBody(CodeInvalidJSON, "Invalid JSON: expected a rules config"))
+					return
+				}
+				c.JSON(http.StatusUnprocessableEntity, errorBody(CodeValidationFailed, err.Error()))
+				return
+			}
+
+			results := []gin.H{}
+			var oldTotal, newTotal, changed int
+			err = receiptStore.WalkReceipts(func(id string, receipt scoring.Receipt, points int) error {
+				breakdown := scoring.Calculate(receipt, proposed)
+				breakdown.Cap(limits.MaxPoints)
+				results = append(results, gin.H{"id": id, "oldPoints": points, "newPoints": breakdown.Total, "delta": breakdown.Total - points})
+				oldTotal += points
+				newTotal += breakdown.Total
+				if breakdown.Total != points {
+					changed++
+				}
+				return nil
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to load receipts"))
+				return
+			}
+			requestLog(c).Info("rules simulated", "receipts", len(results), "changed", changed, "totalDelta", newTotal-oldTotal)
+			respondJSON(c, http.StatusOK, gin.H{
+				"receipts":       results,
+				"count":          len(results),
+				"changed":        changed,
+				"oldTotalPoints": oldTotal,
+				"newTotalPoints": newTotal,
+				"totalDelta":     newTotal - oldTotal,
+			})
+		})
+	}
+	if config.AllowReload {
+		// Reloading re-reads the rules file and swaps the rules in for receipts
+		// scored afterwards; a file that fails to load leaves the old rules in
+		// place.
+		admin.POST("/reload", func(c *gin.Context) {
+			reloaded, err := scoring.LoadRulesConfig(config.RulesFile)
+			if err != nil {
+				requestLog(c).Error("failed to reload rules config", "error", err)
+				c.JSON(http.StatusInternalServerError, errorBody(CodeInternal, "Failed to reload rules: "+err.Error()))
+				return
+			}
+			receiptStore.SetRules(reloaded)
+			requestLog(c).Warn("rules config reloaded", "file", config.RulesFile)
+			respondJSON(c, http.StatusOK, gin.H{"rulesFile": config.RulesFile, "rules": reloaded})
+		})
+	}
+
+	return r, nil
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"receipt-api/scoring"
+)
+
+// newTestRouter opens a memory store with opts and the default rules and
+// builds the router on it with config, closing the store when the test ends.
+func newTestRouter(t *testing.T, config Config, opts StoreOptions) (*gin.Engine, Store) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	opts.Rules = scoring.DefaultRulesConfig()
+	store, err := NewReceiptStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	var ready atomic.Bool
+	ready.Store(true)
+	r, err := newRouter(store, config, slog.New(slog.NewTextHandler(io.Discard, nil)), &ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, store
+}
+
+// serve sends a request to r and returns the recorded response.
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// jsonRequest builds a request with body encoded as JSON.
+func jsonRequest(t *testing.T, method, path string, body any) *http.Request {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// processReceipt stores receipt through POST /v1/receipts/process and returns
+// its ID.
+func processReceipt(t *testing.T, r http.Handler, receipt scoring.Receipt) string {
+	t.Helper()
+	rec := serve(r, jsonRequest(t, http.MethodPost, "/v1/receipts/process", receipt))
+	var body struct{ ID string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.ID == "" {
+		t.Fatalf("process: %d %s", rec.Code, rec.Body)
+	}
+	return body.ID
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	id := processReceipt(t, r, testReceipt("Target"))
+
+	rec := serve(r, httptest.NewRequest(http.MethodGet, "/v1/receipts/"+id+"/points", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "" {
+		t.Errorf("/v1: got %d, Deprecation %q", rec.Code, rec.Header().Get("Deprecation"))
+	}
+
+	path := "/receipts/" + id + "/points"
+	rec = serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("alias: got %d %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("alias: Deprecation = %q, want \"true\"", got)
+	}
+	if got, want := rec.Header().Get("Link"), "</v1"+path+`>; rel="successor-version"`; got != want {
+		t.Errorf("alias: Link = %q, want %q", got, want)
+	}
+
+	if rec := serve(r, httptest.NewRequest(http.MethodGet, "/v2/receipts/"+id+"/points", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("/v2: got %d, want 404", rec.Code)
+	}
+}
+
+func TestProcessRequiresJSONContentType(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	for _, tt := range []struct {
+		contentType string
+		want        int
+	}{
+		{"application/json", http.StatusCreated},
+		{"application/json; charset=utf-8", http.StatusCreated},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	} {
+		req := jsonRequest(t, http.MethodPost, "/v1/receipts/process", testReceipt("Target"))
+		req.Header.Set("Content-Type", tt.contentType)
+		rec := serve(r, req)
+		if rec.Code != tt.want {
+			t.Errorf("Content-Type %q: got %d %s, want %d", tt.contentType, rec.Code, rec.Body, tt.want)
+		}
+		if tt.want == http.StatusUnsupportedMediaType && !strings.Contains(rec.Body.String(), CodeUnsupportedMediaType) {
+			t.Errorf("Content-Type %q: body %s lacks %s", tt.contentType, rec.Body, CodeUnsupportedMediaType)
+		}
+	}
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	config := defaultConfig()
+	config.MaxBodyBytes = 512
+	r, _ := newTestRouter(t, config, StoreOptions{})
+
+	small := testReceipt("Target")
+	large := testReceipt(strings.Repeat("A", 1024))
+	for _, tt := range []struct {
+		path string
+		body any
+		want int
+	}{
+		{"/v1/receipts/process", small, http.StatusCreated},
+		{"/v1/receipts/process", large, http.StatusRequestEntityTooLarge},
+		{"/v1/receipts/process/batch", []scoring.Receipt{small}, http.StatusOK},
+		{"/v1/receipts/process/batch", []scoring.Receipt{large}, http.StatusRequestEntityTooLarge},
+	} {
+		rec := serve(r, jsonRequest(t, http.MethodPost, tt.path, tt.body))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d %s, want %d", tt.path, rec.Code, rec.Body, tt.want)
+		}
+		if tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), CodeBodyTooLarge) {
+			t.Errorf("%s: body %s lacks %s", tt.path, rec.Body, CodeBodyTooLarge)
+		}
+	}
+
+	// Zero turns the limit off.
+	config.MaxBodyBytes = 0
+	r, _ = newTestRouter(t, config, StoreOptions{})
+	if rec := serve(r, jsonRequest(t, http.MethodPost, "/v1/receipts/process", large)); rec.Code != http.StatusCreated {
+		t.Errorf("no limit: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProcessAnswersCreatedWithLocation(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	for _, prefix := range []string{"/v1", ""} {
+		rec := serve(r, jsonRequest(t, http.MethodPost, prefix+"/receipts/process", testReceipt("Target")))
+		var body struct{ ID string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != http.StatusCreated || err != nil {
+			t.Fatalf("%q: got %d %s, want 201", prefix, rec.Code, rec.Body)
+		}
+		location := rec.Header().Get("Location")
+		if want := prefix + "/receipts/" + body.ID + "/points"; location != want {
+			t.Errorf("%q: Location = %q, want %q", prefix, location, want)
+		}
+		if rec := serve(r, httptest.NewRequest(http.MethodGet, location, nil)); rec.Code != http.StatusOK {
+			t.Errorf("%q: GET Location got %d", prefix, rec.Code)
+		}
+	}
+
+	rec := serve(r, jsonRequest(t, http.MethodPost, "/v1/receipts/process?includePoints=true", testReceipt("Target")))
+	if !strings.Contains(rec.Body.String(), `"points":`) {
+		t.Errorf("includePoints: body %s has no points", rec.Body)
+	}
+}
+
+func TestProcessDuplicateOfPendingAnswersAccepted(t *testing.T) {
+	r, store := newTestRouter(t, defaultConfig(), StoreOptions{Deduplicate: true})
+	id := processReceipt(t, r, testReceipt("Target"))
+	markPending(t, store, id)
+
+	rec := serve(r, jsonRequest(t, http.MethodPost, "/v1/receipts/process", testReceipt("Target")))
+	var body struct{ ID, Status string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusAccepted || body.ID != id || body.Status != "pending" {
+		t.Errorf("got %d %s, want 202 pending for %s", rec.Code, rec.Body, id)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	ids := map[string]bool{
+		processReceipt(t, r, testReceipt("Target")):    true,
+		processReceipt(t, r, testReceipt("=1+1 Mart")): true,
+	}
+
+	rec := serve(r, httptest.NewRequest(http.MethodGet, "/v1/receipts/export.csv", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("got %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != "id,retailer,purchaseDate,purchaseTime,total,itemCount,points" {
+		t.Fatalf("rows = %q", rows)
+	}
+	retailers := map[string]bool{}
+	for _, row := range rows[1:] {
+		if !ids[row[0]] {
+			t.Errorf("unexpected id %q", row[0])
+		}
+		retailers[row[1]] = true
+	}
+	if !retailers["Target"] || !retailers["'=1+1 Mart"] {
+		t.Errorf("retailers = %v, want Target and the escaped formula", retailers)
+	}
+}
+
+func TestPointsConditionalGet(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	path := "/v1/receipts/" + processReceipt(t, r, testReceipt("Target")) + "/points"
+
+	rec := serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Vary") != "Accept" {
+		t.Fatalf("got %d, ETag %q, Vary %q", rec.Code, etag, rec.Header().Get("Vary"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = serve(r, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("matching If-None-Match: got %d %q, want an empty 304", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != etag || rec.Header().Get("Vary") != "Accept" {
+		t.Errorf("304 headers: ETag %q, Vary %q", rec.Header().Get("ETag"), rec.Header().Get("Vary"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	if rec := serve(r, req); rec.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: got %d, want 200", rec.Code)
+	}
+
+	// The plain-text form is a different representation with its own tag.
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set("If-None-Match", etag)
+	rec = serve(r, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("text/plain: got %d, ETag %q; want 200 with a new tag", rec.Code, rec.Header().Get("ETag"))
+	}
+	if body := rec.Body.String(); strings.TrimSpace(body) == "" || strings.Contains(body, "{") {
+		t.Errorf("text/plain body = %q", body)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	r, store := newTestRouter(t, defaultConfig(), StoreOptions{})
+
+	valid, err := json.Marshal(testReceipt("Target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(valid) + "\n\n{\n" + `{"retailer":"Target"}` + "\n"
+	req := httptest.NewRequest(http.MethodPost, "/v1/receipts/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", mimeNDJSON)
+	rec := serve(r, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mimeNDJSON {
+		t.Fatalf("got %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	type lineResult struct {
+		Line   int
+		Status int
+		ID     string
+	}
+	var results []lineResult
+	decoder := json.NewDecoder(rec.Body)
+	for decoder.More() {
+		var result lineResult
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	// The blank line is skipped but still counted.
+	want := []struct{ line, status int }{{1, http.StatusOK}, {3, http.StatusBadRequest}, {4, http.StatusUnprocessableEntity}}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v", results)
+	}
+	for i, w := range want {
+		if results[i].Line != w.line || results[i].Status != w.status {
+			t.Errorf("result %d = %+v, want line %d status %d", i, results[i], w.line, w.status)
+		}
+	}
+	if exists, err := store.Exists(results[0].ID); err != nil || !exists {
+		t.Errorf("imported receipt %q not stored: %v", results[0].ID, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/receipts/import", strings.NewReader(string(valid)))
+	req.Header.Set("Content-Type", "application/json")
+	if rec := serve(r, req); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON body: got %d, want 415", rec.Code)
+	}
+}
+
+func TestAdminRules(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	if rec := serve(r, httptest.NewRequest(http.MethodGet, "/admin/rules", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("admin off: got %d, want 404", rec.Code)
+	}
+
+	config := defaultConfig()
+	config.Admin = true
+	r, _ = newTestRouter(t, config, StoreOptions{})
+	rec := serve(r, httptest.NewRequest(http.MethodGet, "/admin/rules", nil))
+	var body struct {
+		Rules scoring.RulesConfig
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if body.Rules.RoundDollarPoints != scoring.DefaultRulesConfig().RoundDollarPoints {
+		t.Errorf("rules = %+v, want the defaults", body.Rules)
+	}
+}
+
+func TestAdminSimulate(t *testing.T) {
+	config := defaultConfig()
+	config.Admin = true
+	r, store := newTestRouter(t, config, StoreOptions{})
+	id := processReceipt(t, r, testReceipt("Target"))
+	before, _, err := store.GetPoints(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Doubling the retailer points adds one per character of "Target".
+	rec := serve(r, jsonRequest(t, http.MethodPost, "/admin/simulate", map[string]int{"retailerCharPoints": 2}))
+	var body struct {
+		Count, Changed, TotalDelta int
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if body.Count != 1 || body.Changed != 1 || body.TotalDelta != 6 {
+		t.Errorf("simulation = %+v, want 1 receipt changed by 6", body)
+	}
+	if after, _, err := store.GetPoints(id); err != nil || after != before {
+		t.Errorf("stored points changed from %d to %d (%v)", before, after, err)
+	}
+
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"retailerCharPoints": 2`, http.StatusBadRequest},
+		{`{"retailerCharPoint": 2}`, http.StatusUnprocessableEntity},
+		{`{"itemGroupSize": 0}`, http.StatusUnprocessableEntity},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/simulate", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if rec := serve(r, req); rec.Code != tt.want {
+			t.Errorf("%s: got %d %s, want %d", tt.body, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestExpiredReceiptsAnswerGone(t *testing.T) {
+	r, store := newTestRouter(t, defaultConfig(), StoreOptions{TTL: time.Hour})
+	id := processReceipt(t, r, testReceipt("Target"))
+
+	// Age the receipt past the TTL.
+	memory := store.(*ReceiptStore)
+	memory.mu.Lock()
+	stored := memory.receipts[id]
+	stored.CreatedAt = stored.CreatedAt.Add(-2 * time.Hour)
+	memory.receipts[id] = stored
+	memory.mu.Unlock()
+
+	for _, path := range []string{"/points", "/points/breakdown", ""} {
+		rec := serve(r, httptest.NewRequest(http.MethodGet, "/v1/receipts/"+id+path, nil))
+		if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), CodeReceiptExpired) {
+			t.Errorf("%q: got %d %s, want 410 %s", path, rec.Code, rec.Body, CodeReceiptExpired)
+		}
+	}
+
+	rec := serve(r, httptest.NewRequest(http.MethodGet, "/v1/receipts/"+uuid.NewString()+"/points", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), CodeReceiptNotFound) {
+		t.Errorf("unknown ID: got %d %s, want 404 %s", rec.Code, rec.Body, CodeReceiptNotFound)
+	}
+}
+
+func TestVerbosePoints(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	start := time.Now()
+	id := processReceipt(t, r, testReceipt("Target"))
+	path := "/v1/receipts/" + id + "/points"
+
+	var plain, verbose ScoreInfo
+	rec := serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &plain); err != nil || strings.Contains(rec.Body.String(), "computedAt") {
+		t.Errorf("plain: body %s", rec.Body)
+	}
+
+	rec = serve(r, httptest.NewRequest(http.MethodGet, path+"?verbose=true", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &verbose); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("verbose: got %d %s", rec.Code, rec.Body)
+	}
+	if verbose.Points != plain.Points || verbose.ComputedAt.Before(start) || verbose.Rescored {
+		t.Errorf("verbose = %+v, want %d points computed since %v", verbose, plain.Points, start)
+	}
+	if rec.Header().Get("ETag") != "" {
+		t.Errorf("verbose response has ETag %q", rec.Header().Get("ETag"))
+	}
+
+	rec = serve(r, httptest.NewRequest(http.MethodPost, "/v1/receipts/"+id+"/rescore", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rescore: got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(r, httptest.NewRequest(http.MethodGet, path+"?verbose=true", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &verbose); err != nil || !verbose.Rescored {
+		t.Errorf("after rescore: body %s, want rescored", rec.Body)
+	}
+}
+
+func TestPointsHead(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	id := processReceipt(t, r, testReceipt("Target"))
+	// A real server, since only it drops the body of a HEAD response.
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	for _, tt := range []struct {
+		id   string
+		want int
+	}{
+		{id, http.StatusOK},
+		{uuid.NewString(), http.StatusNotFound},
+		{"not-a-uuid", http.StatusBadRequest},
+	} {
+		resp, err := http.Head(server.URL + "/v1/receipts/" + tt.id + "/points")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want || len(body) != 0 {
+			t.Errorf("%s: got %d with %d body bytes, want %d and none", tt.id, resp.StatusCode, len(body), tt.want)
+		}
+		if tt.want == http.StatusOK && resp.Header.Get("ETag") == "" {
+			t.Errorf("%s: no ETag", tt.id)
+		}
+	}
+}
+
+func TestReceiptRoutesRejectMalformedIDs(t *testing.T) {
+	r, _ := newTestRouter(t, defaultConfig(), StoreOptions{})
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/v1/receipts/not-a-uuid"},
+		{http.MethodDelete, "/v1/receipts/not-a-uuid"},
+		{http.MethodGet, "/v1/receipts/not-a-uuid/points"},
+		{http.MethodGet, "/v1/receipts/not-a-uuid/points/breakdown"},
+		{http.MethodGet, "/v1/receipts/not-a-uuid/exists"},
+		{http.MethodPost, "/v1/receipts/not-a-uuid/rescore"},
+	} {
+		rec := serve(r, httptest.NewRequest(route.method, route.path, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), CodeInvalidID) {
+			t.Errorf("%s %s: got %d %s, want 400 %s", route.method, route.path, rec.Code, rec.Body, CodeInvalidID)
+		}
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	config := defaultConfig()
+	config.Admin = true
+	r, _ := newTestRouter(t, config, StoreOptions{})
+	rec := serve(r, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodeNotPersisted) {
+		t.Errorf("no data file: got %d %s, want 409 %s", rec.Code, rec.Body, CodeNotPersisted)
+	}
+
+	config.DataFile = filepath.Join(t.TempDir(), "receipts.json")
+	r, _ = newTestRouter(t, config, StoreOptions{Path: config.DataFile})
+	processReceipt(t, r, testReceipt("Target"))
+	processReceipt(t, r, testReceipt("Walmart"))
+
+	rec = serve(r, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+	var body struct {
+		Path      string
+		Persisted int
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if body.Path != config.DataFile || body.Persisted != 2 {
+		t.Errorf("flush = %+v, want 2 receipts in %s", body, config.DataFile)
+	}
+}
@@ -0,0 +1,146 @@
+package scoring
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// currencyMinorUnits lists the ISO 4217 currencies whose minor unit isn't a
+// hundredth, mapped to the number of decimal places their amounts are written
+// with. Every other currency, and a receipt without one, uses two.
+var currencyMinorUnits = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3,
+	"LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0, "XAF": 0, "XOF": 0,
+}
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// MinorUnits returns how many decimal places amounts in currency have, such
+// as 2 for "USD" and 0 for "JPY". An empty or unlisted currency has 2.
+func MinorUnits(currency string) int {
+	if digits, ok := currencyMinorUnits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// pow10 returns 10 to the power of n, for the small n MinorUnits returns.
+func pow10(n int) int64 {
+	p := int64(1)
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
+// isAmount reports whether amount is written as digits with exactly the given
+// number of decimal places, such as "35.00" for two or "35" for none.
+func isAmount(amount string, digits int) bool {
+	whole, frac, hasPoint := strings.Cut(amount, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" {
+		return false
+	}
+	if digits == 0 {
+		return !hasPoint
+	}
+	return hasPoint && len(frac) == digits && strings.Trim(frac, "0123456789") == ""
+}
+
+// amountMessage describes the format isAmount expects, for validation errors.
+// example is written with two decimal places and adjusted to digits.
+func amountMessage(currency string, digits int, example string) string {
+	if currency == "" {
+		return "must be a dollar amount with two decimal places, e.g. " + strconv.Quote(example)
+	}
+
+	whole, _, _ := strings.Cut(example, ".")
+	switch digits {
+	case 0:
+		return "must be a whole " + currency + " amount with no decimal places, e.g. " + strconv.Quote(whole)
+	case 2:
+		return "must be a " + currency + " amount with two decimal places, e.g. " + strconv.Quote(example)
+	default:
+		return "must be a " + currency + " amount with " + strconv.Itoa(digits) + " decimal places, e.g. " + strconv.Quote(example+strings.Repeat("0", digits-2))
+	}
+}
+
+// padAmount rewrites a decimal amount with fewer decimal places than digits,
+// such as "35" or "35.0" for two, with exactly digits, so equivalent
+// spellings are stored and scored alike. Other values, including those with
+// extra places, are returned unchanged.
+func padAmount(amount string, digits int) string {
+	whole, frac, hasPoint := strings.Cut(amount, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(frac, "0123456789") != "" {
+		return amount
+	}
+	if len(frac) >= digits || (hasPoint && frac == "") {
+		return amount
+	}
+	return whole + "." + frac + strings.Repeat("0", digits-len(frac))
+}
+
+// roundAmount rewrites a decimal amount with exactly digits decimal places,
+// padding with zeros or rounding half up. Amounts that aren't plain decimal
+// numbers, or that overflow, are returned unchanged.
+func roundAmount(amount string, digits int) string {
+	whole, frac, _ := strings.Cut(amount, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(frac, "0123456789") != "" {
+		return amount
+	}
+
+	roundUp := len(frac) > digits && frac[digits] >= '5'
+	if len(frac) > digits {
+		frac = frac[:digits]
+	}
+	minor, err := parseMinorUnits(whole+"."+frac, digits)
+	if err != nil || (roundUp && minor == math.MaxInt64) {
+		return amount
+	}
+	if roundUp {
+		minor++
+	}
+	return formatMinorUnits(minor, digits)
+}
+
+// parseMinorUnits parses a decimal amount such as "14.25" into whole minor
+// units, cents for two decimal places, using integer arithmetic to avoid the
+// rounding errors of float multiplication. Up to digits fractional digits are
+// accepted.
+func parseMinorUnits(amount string, digits int) (int64, error) {
+	whole, frac, _ := strings.Cut(amount, ".")
+	if whole == "" || len(frac) > digits || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, errors.New("invalid amount: " + amount)
+	}
+
+	unit := pow10(digits)
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major > math.MaxInt64/unit-1 {
+		return 0, errors.New("invalid amount: " + amount)
+	}
+
+	var minor int64
+	if frac != "" {
+		frac += strings.Repeat("0", digits-len(frac))
+		m, err := strconv.ParseUint(frac, 10, 16)
+		if err != nil {
+			return 0, errors.New("invalid amount: " + amount)
+		}
+		minor = int64(m)
+	}
+
+	return major*unit + minor, nil
+}
+
+// formatMinorUnits renders an amount in minor units with the given number of
+// decimal places, such as "35.35" for 3535 with two.
+func formatMinorUnits(amount int64, digits int) string {
+	if digits == 0 {
+		return strconv.FormatInt(amount, 10)
+	}
+	unit := pow10(digits)
+	fraction := strconv.FormatInt(amount%unit, 10)
+	return strconv.FormatInt(amount/unit, 10) + "." + strings.Repeat("0", digits-len(fraction)) + fraction
+}
@@ -0,0 +1,79 @@
+package scoring
+
+import "testing"
+
+func TestParseMinorUnits(t *testing.T) {
+	for _, tt := range []struct {
+		amount string
+		digits int
+		want   int64
+		ok     bool
+	}{
+		{"14.25", 2, 1425, true},
+		{"0.29", 2, 29, true},
+		{"4.35", 2, 435, true},
+		{"35", 2, 3500, true},
+		{"35.5", 2, 3550, true},
+		{"35.001", 2, 0, false},
+		{"1000", 0, 1000, true},
+		{"10.5", 0, 0, false},
+		{"6.490", 3, 6490, true},
+		{"-1.00", 2, 0, false},
+		{"", 2, 0, false},
+		{"999999999999999999999.00", 2, 0, false},
+	} {
+		got, err := parseMinorUnits(tt.amount, tt.digits)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseMinorUnits(%q, %d) = %d, %v; want %d, ok %v", tt.amount, tt.digits, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTotalRulesWithCurrency(t *testing.T) {
+	for _, tt := range []struct {
+		currency, total string
+		round, quarter  int
+	}{
+		{"USD", "10.00", 50, 25},
+		{"USD", "10.50", 0, 25},
+		{"JPY", "1000", 50, 25},
+		{"JPY", "1001", 50, 25},
+		{"KWD", "10.000", 50, 25},
+		{"KWD", "10.250", 0, 25},
+		{"KWD", "10.125", 0, 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Currency = tt.currency
+		receipt.Total = tt.total
+		breakdown := Calculate(Normalize(receipt), DefaultRulesConfig())
+		if got := breakdown.Rules[RuleRoundDollarTotal]; got != tt.round {
+			t.Errorf("%s %s: round dollar points = %d, want %d", tt.currency, tt.total, got, tt.round)
+		}
+		if got := breakdown.Rules[RuleQuarterTotal]; got != tt.quarter {
+			t.Errorf("%s %s: quarter points = %d, want %d", tt.currency, tt.total, got, tt.quarter)
+		}
+	}
+}
+
+func TestValidateCurrency(t *testing.T) {
+	for _, tt := range []struct {
+		currency, total, price string
+		valid                  bool
+	}{
+		{"USD", "6.49", "6.49", true},
+		{"", "6.49", "6.49", true},
+		{"JPY", "1000", "1000", true},
+		{"jpy", "1000", "1000", true},
+		{"JPY", "1000", "1000.00", false},
+		{"KWD", "6.490", "6.490", true},
+		{"US", "6.49", "6.49", false},
+	} {
+		receipt := sampleReceipt()
+		receipt.Currency = tt.currency
+		receipt.Total = tt.total
+		receipt.Items = []Item{{ShortDescription: "Item", Price: tt.price}}
+		if valid := Validate(Normalize(receipt), DefaultLimits()) == nil; valid != tt.valid {
+			t.Errorf("%s %s/%s: valid %v, want %v", tt.currency, tt.total, tt.price, valid, tt.valid)
+		}
+	}
+}
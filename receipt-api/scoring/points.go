@@ -0,0 +1,137 @@
+package scoring
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rule names used as keys in a PointsBreakdown.
+const (
+	RuleRetailerName      = "retailerName"
+	RuleRoundDollarTotal  = "roundDollarTotal"
+	RuleQuarterTotal      = "quarterMultipleTotal"
+	RuleItemPairs         = "itemPairs"
+	RuleItemDescription   = "itemDescription"
+	RuleOddPurchaseDay    = "oddPurchaseDay"
+	RuleAfternoonPurchase = "afternoonPurchase"
+	RuleItemCategory      = "itemCategory"
+	RuleWholeDollarItems  = "wholeDollarItems"
+	RuleItemSubtotal      = "itemSubtotal"
+	RuleFuturePurchase    = "futurePurchaseDate"
+	RulePalindromeName    = "palindromeRetailer"
+	RuleDescriptionCount  = "itemDescriptionCount"
+	RuleLongRetailerName  = "longRetailerName"
+	RuleMultiplier        = "globalMultiplier"
+	RulePointsCap         = "pointsCap"
+)
+
+// PointsBreakdown records how many points each rule contributed to a
+// receipt's total.
+type PointsBreakdown struct {
+	Rules map[string]int `json:"rules"`
+	Total int            `json:"total"`
+}
+
+// Add records points contributed by rule.
+func (b *PointsBreakdown) Add(rule string, points int) {
+	b.Rules[rule] += points
+	b.Total += points
+}
+
+// Multiply scales the total by multiplier, rounded according to mode, and
+// records the change under RuleMultiplier. A multiplier of zero or one leaves
+// the total alone.
+func (b *PointsBreakdown) Multiply(multiplier float64, mode string) {
+	if multiplier == 0 || multiplier == 1 {
+		return
+	}
+	b.Add(RuleMultiplier, int(round(mode, float64(b.Total)*multiplier))-b.Total)
+}
+
+// Cap clamps the total to max points, recording the reduction under
+// RulePointsCap. A max of zero or less means no cap.
+func (b *PointsBreakdown) Cap(max int) {
+	if max > 0 && b.Total > max {
+		b.Add(RulePointsCap, max-b.Total)
+	}
+}
+
+// alphanumericCount counts the letters and digits in s. Any Unicode letter
+// or digit counts, so "Café Ω" has five. ASCII bytes, which most retailer
+// names consist of, are looked up in a table without decoding a rune.
+func alphanumericCount(s string) int {
+	count := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if asciiAlphanumeric[b] {
+				count++
+			}
+			i++
+			continue
+		}
+		char, size := utf8.DecodeRuneInString(s[i:])
+		if isAlphanumeric(char) {
+			count++
+		}
+		i += size
+	}
+	return count
+}
+
+// asciiAlphanumeric records which ASCII characters isAlphanumeric accepts.
+var asciiAlphanumeric = func() (table [utf8.RuneSelf]bool) {
+	for char := range table {
+		table[char] = isAlphanumeric(rune(char))
+	}
+	return table
+}()
+
+// isAlphanumeric reports whether char is a letter or digit, as counted by
+// the retailer name rules.
+func isAlphanumeric(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char)
+}
+
+// Calculate scores a receipt with the given rules, assuming it has already
+// been normalized and validated. Each rule in rules.Rules() contributes its
+// points to the breakdown under its name, then the total is scaled by
+// rules.GlobalMultiplier and capped at rules.MaxPoints.
+func Calculate(receipt Receipt, rules RulesConfig) PointsBreakdown {
+	breakdown := PointsBreakdown{Rules: make(map[string]int)}
+	for _, rule := range rules.Rules() {
+		breakdown.Add(rule.Name(), rule.Points(receipt))
+	}
+
+	breakdown.Multiply(rules.GlobalMultiplier, rules.DescriptionRounding)
+	breakdown.Cap(rules.MaxPoints)
+	return breakdown
+}
+
+// BatchBonus returns the cross-shopping bonus for a batch whose stored
+// receipts have the given retailers: rules.BatchRetailerPoints if they span
+// at least rules.BatchRetailerThreshold distinct retailers, comparing names as
+// NormalizeRetailer does, and zero otherwise.
+func BatchBonus(retailers []string, rules RulesConfig) int {
+	distinct := make(map[string]bool)
+	for _, retailer := range retailers {
+		distinct[NormalizeRetailer(retailer)] = true
+	}
+	if rules.BatchRetailerPoints == 0 || len(distinct) < rules.BatchRetailerThreshold {
+		return 0
+	}
+	return rules.BatchRetailerPoints
+}
+
+// CalculatePoints normalizes, validates and scores a receipt with the default
+// limits and rules, returning its total points.
+func CalculatePoints(receipt Receipt) (int, error) {
+	limits := DefaultLimits()
+	receipt = Normalize(receipt)
+	if err := Validate(receipt, limits); err != nil {
+		return 0, err
+	}
+
+	breakdown := Calculate(receipt, DefaultRulesConfig())
+	breakdown.Cap(limits.MaxPoints)
+	return breakdown.Total, nil
+}
@@ -0,0 +1,407 @@
+package scoring
+
+import (
+	"strings"
+	"testing"
+)
+
+// sampleReceipt returns a valid receipt; tests change the fields they care
+// about.
+func sampleReceipt() Receipt {
+	return Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		},
+		Total: "18.74",
+	}
+}
+
+func TestCommaDecimalSeparators(t *testing.T) {
+	for _, tt := range []struct {
+		total          string
+		round, quarter int
+	}{
+		{"35.00", 50, 25},
+		{"35,00", 50, 25},
+		{"14,25", 0, 25},
+		{"14,30", 0, 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Items = []Item{{ShortDescription: "Gatorade", Price: "2,25"}}
+		receipt.Total = tt.total
+		receipt = Normalize(receipt)
+		if err := Validate(receipt, DefaultLimits()); err != nil {
+			t.Errorf("total %q: Validate = %v", tt.total, err)
+			continue
+		}
+		if receipt.Items[0].Price != "2.25" {
+			t.Errorf("price \"2,25\" normalized to %q", receipt.Items[0].Price)
+		}
+		breakdown := Calculate(receipt, DefaultRulesConfig())
+		if got := breakdown.Rules[RuleRoundDollarTotal]; got != tt.round {
+			t.Errorf("total %q: round dollar points = %d, want %d", tt.total, got, tt.round)
+		}
+		if got := breakdown.Rules[RuleQuarterTotal]; got != tt.quarter {
+			t.Errorf("total %q: quarter points = %d, want %d", tt.total, got, tt.quarter)
+		}
+	}
+
+	// Thousands separators aren't decimal commas and are left to validation.
+	receipt := Normalize(Receipt{Total: "1,000"})
+	if receipt.Total != "1,000" {
+		t.Errorf("\"1,000\" normalized to %q", receipt.Total)
+	}
+}
+
+func TestAfternoonRule(t *testing.T) {
+	for _, tt := range []struct {
+		time string
+		want int
+	}{
+		{"13:59", 0},
+		{"14:00", 10},
+		{"14:00:00", 10},
+		{"2:00 PM", 10},
+		{"3:59 PM", 10},
+		{"15:59:59", 10},
+		{"16:00", 0},
+		{"4:00 PM", 0},
+		{"2:30 AM", 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseTime = tt.time
+		if got := Calculate(receipt, DefaultRulesConfig()).Rules[RuleAfternoonPurchase]; got != tt.want {
+			t.Errorf("%s: afternoon points = %d, want %d", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestTotalRules(t *testing.T) {
+	for _, tt := range []struct {
+		total          string
+		round, quarter int
+	}{
+		{"35.00", 50, 25},
+		{"100.00", 50, 25},
+		{"14.25", 0, 25},
+		{"0.25", 0, 25},
+		{"8.75", 0, 25},
+		{"1.15", 0, 0},
+		{"0.29", 0, 0},
+		{"19.99", 0, 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Total = tt.total
+		breakdown := Calculate(receipt, DefaultRulesConfig())
+		if got := breakdown.Rules[RuleRoundDollarTotal]; got != tt.round {
+			t.Errorf("total %q: round dollar points = %d, want %d", tt.total, got, tt.round)
+		}
+		if got := breakdown.Rules[RuleQuarterTotal]; got != tt.quarter {
+			t.Errorf("total %q: quarter points = %d, want %d", tt.total, got, tt.quarter)
+		}
+	}
+}
+
+func TestItemCategoryRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.CategoryKeyword = "organic"
+	rules.CategoryPoints = 15
+
+	for _, tt := range []struct {
+		name         string
+		descriptions []string
+		want         int
+	}{
+		{"uniform", []string{"Organic Milk", "ORGANIC eggs", "bread (organic)"}, 15},
+		{"mixed", []string{"Organic Milk", "Eggs"}, 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Items = nil
+		for _, d := range tt.descriptions {
+			receipt.Items = append(receipt.Items, Item{ShortDescription: d, Price: "1.00"})
+		}
+		if got := Calculate(receipt, rules).Rules[RuleItemCategory]; got != tt.want {
+			t.Errorf("%s: category points = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if _, ok := Calculate(sampleReceipt(), DefaultRulesConfig()).Rules[RuleItemCategory]; ok {
+		t.Error("category rule runs without a keyword")
+	}
+}
+
+func TestRetailerNameRuleCountsUnicode(t *testing.T) {
+	for _, tt := range []struct {
+		retailer string
+		want     int
+	}{
+		{"Target", 6},
+		{"M&M Corner Market", 14},
+		{"Café Ω", 5},
+		{"東京マート", 5},
+		{"  &&  ", 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Retailer = tt.retailer
+		if got := Calculate(receipt, DefaultRulesConfig()).Rules[RuleRetailerName]; got != tt.want {
+			t.Errorf("%q: retailer name points = %d, want %d", tt.retailer, got, tt.want)
+		}
+	}
+}
+
+func TestOddDayRule(t *testing.T) {
+	for _, tt := range []struct {
+		date string
+		want int
+	}{
+		{"2022-01-01", 6},
+		{"2022-01-02", 0},
+		{"2022-01-31", 6},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseDate = tt.date
+		if got := Calculate(receipt, DefaultRulesConfig()).Rules[RuleOddPurchaseDay]; got != tt.want {
+			t.Errorf("%s: odd day points = %d, want %d", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestWholeDollarItemsRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.WholeDollarItemPoints = 10
+
+	for _, tt := range []struct {
+		name   string
+		prices []string
+		want   int
+	}{
+		{"all whole", []string{"1.00", "12.00"}, 10},
+		{"mixed", []string{"1.00", "12.25"}, 0},
+		{"none whole", []string{"1.01"}, 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Items = nil
+		for _, p := range tt.prices {
+			receipt.Items = append(receipt.Items, Item{ShortDescription: "Item", Price: p})
+		}
+		if got := Calculate(receipt, rules).Rules[RuleWholeDollarItems]; got != tt.want {
+			t.Errorf("%s: whole dollar points = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestItemDescriptionRounding(t *testing.T) {
+	for _, tt := range []struct {
+		mode  string
+		price string
+		want  int
+	}{
+		// 2.25 * 0.2 = 0.45
+		{RoundingCeil, "2.25", 1},
+		{RoundingFloor, "2.25", 0},
+		{RoundingRound, "2.25", 0},
+		{"", "2.25", 1},
+		// 12.00 * 0.2 = 2.4
+		{RoundingCeil, "12.00", 3},
+		{RoundingFloor, "12.00", 2},
+		{RoundingRound, "12.00", 2},
+	} {
+		rules := DefaultRulesConfig()
+		rules.DescriptionRounding = tt.mode
+		receipt := sampleReceipt()
+		receipt.Items = []Item{
+			{ShortDescription: "  Gatorade  ", Price: tt.price}, // 8 characters trimmed
+			{ShortDescription: "Bag", Price: tt.price},
+		}
+		if got := Calculate(receipt, rules).Rules[RuleItemDescription]; got != tt.want {
+			t.Errorf("%q rounding of %s: points = %d, want %d", tt.mode, tt.price, got, tt.want)
+		}
+	}
+}
+
+// exampleReceipts are the two worked examples from the original challenge.
+var exampleReceipts = []struct {
+	receipt Receipt
+	points  int
+}{
+	{Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+		Total: "35.35",
+	}, 28},
+	{Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}, 109},
+}
+
+func TestMaxPoints(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.MaxPoints = 50
+	for _, ex := range exampleReceipts {
+		want := min(ex.points, 50)
+		breakdown := Calculate(ex.receipt, rules)
+		if breakdown.Total != want {
+			t.Errorf("%s: total = %d, want %d", ex.receipt.Retailer, breakdown.Total, want)
+		}
+		if got := breakdown.Rules[RulePointsCap]; got != want-ex.points {
+			t.Errorf("%s: cap points = %d, want %d", ex.receipt.Retailer, got, want-ex.points)
+		}
+	}
+}
+
+func TestMorningWindow(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.AfternoonStart = "09:00"
+	rules.AfternoonEnd = "11:00"
+	for _, tt := range []struct {
+		time string
+		want int
+	}{
+		{"08:59", 0},
+		{"09:00", 10},
+		{"10:59", 10},
+		{"11:00", 0},
+		{"14:30", 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseTime = tt.time
+		if got := Calculate(receipt, rules).Rules[RuleAfternoonPurchase]; got != tt.want {
+			t.Errorf("%s: window points = %d, want %d", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestGlobalMultiplier(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.GlobalMultiplier = 2
+	for _, ex := range exampleReceipts {
+		breakdown := Calculate(ex.receipt, rules)
+		if breakdown.Total != 2*ex.points {
+			t.Errorf("%s: total = %d, want %d", ex.receipt.Retailer, breakdown.Total, 2*ex.points)
+		}
+		if got := breakdown.Rules[RuleMultiplier]; got != ex.points {
+			t.Errorf("%s: multiplier points = %d, want %d", ex.receipt.Retailer, got, ex.points)
+		}
+	}
+
+	// The multiplier applies before the cap.
+	rules.MaxPoints = 100
+	if got := Calculate(exampleReceipts[0].receipt, rules).Total; got != 56 {
+		t.Errorf("doubled Target receipt under a 100 cap = %d, want 56", got)
+	}
+	if got := Calculate(exampleReceipts[1].receipt, rules).Total; got != 100 {
+		t.Errorf("doubled M&M receipt under a 100 cap = %d, want 100", got)
+	}
+}
+
+func TestRoundDollarTotalSpellings(t *testing.T) {
+	for _, total := range []string{"35", "35.0", "35.00"} {
+		receipt := sampleReceipt()
+		receipt.Total = total
+		receipt = Normalize(receipt)
+		if err := Validate(receipt, DefaultLimits()); err != nil {
+			t.Errorf("total %q: Validate = %v", total, err)
+			continue
+		}
+		if receipt.Total != "35.00" {
+			t.Errorf("total %q normalized to %q, want \"35.00\"", total, receipt.Total)
+		}
+		breakdown := Calculate(receipt, DefaultRulesConfig())
+		if got := breakdown.Rules[RuleRoundDollarTotal]; got != 50 {
+			t.Errorf("total %q: round dollar points = %d, want 50", total, got)
+		}
+	}
+
+	receipt := sampleReceipt()
+	receipt.Total = "35"
+	if _, err := CalculatePoints(receipt); err != nil {
+		t.Errorf("CalculatePoints with total \"35\": %v", err)
+	}
+}
+
+func TestPadAmount(t *testing.T) {
+	for _, tt := range []struct {
+		amount string
+		digits int
+		want   string
+	}{
+		{"35", 2, "35.00"},
+		{"35.0", 2, "35.00"},
+		{"35.00", 2, "35.00"},
+		{"35.001", 2, "35.001"},
+		{"35.", 2, "35."},
+		{"35", 0, "35"},
+		{"35", 3, "35.000"},
+		{"abc", 2, "abc"},
+		{"-35", 2, "-35"},
+	} {
+		if got := padAmount(tt.amount, tt.digits); got != tt.want {
+			t.Errorf("padAmount(%q, %d) = %q, want %q", tt.amount, tt.digits, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkAlphanumericCount measures the character count behind rule 1 on
+// its own, for comparison with BenchmarkRetailerNameRule in package main.
+func BenchmarkAlphanumericCount(b *testing.B) {
+	for _, bm := range []struct {
+		name     string
+		retailer string
+	}{
+		{"short", "M&M Corner Market"},
+		{"ascii", strings.Repeat("Walgreens #1234 ", 112) + "Pharmacy"},
+		{"unicode", strings.Repeat("Café Ω Ünïcödé ", 73) + "Markt"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for range b.N {
+				alphanumericCount(bm.retailer)
+			}
+		})
+	}
+}
+
+func TestBatchBonus(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.BatchRetailerThreshold = 3
+	rules.BatchRetailerPoints = 25
+
+	for _, tt := range []struct {
+		name      string
+		retailers []string
+		want      int
+	}{
+		{"single retailer", []string{"Target", "Target", " Target ", "Target"}, 0},
+		{"below threshold", []string{"Target", "Walmart"}, 0},
+		{"multi retailer", []string{"Target", "Walmart", "Costco"}, 25},
+	} {
+		if got := BatchBonus(tt.retailers, rules); got != tt.want {
+			t.Errorf("%s: BatchBonus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	rules.BatchRetailerPoints = 0
+	if got := BatchBonus([]string{"Target", "Walmart", "Costco"}, rules); got != 0 {
+		t.Errorf("rule off: BatchBonus = %d, want 0", got)
+	}
+}
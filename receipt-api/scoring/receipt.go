@@ -0,0 +1,100 @@
+// Package scoring implements receipt validation and the points rules used by
+// the receipt API, so they can be used without running the HTTP server.
+package scoring
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Receipt is a submitted receipt. The purchase date and time may be given
+// either separately or as a single ISO 8601 PurchaseDateTime, which Normalize
+// splits into the separate fields.
+type Receipt struct {
+	Retailer         string `json:"retailer" binding:"required"`
+	PurchaseDate     string `json:"purchaseDate" binding:"required_without=PurchaseDateTime"`
+	PurchaseTime     string `json:"purchaseTime" binding:"required_without=PurchaseDateTime"`
+	PurchaseDateTime string `json:"purchaseDateTime,omitempty"`
+	Items            []Item `json:"items" binding:"dive"`
+	Total            string `json:"total" binding:"required"`
+
+	// Currency is the ISO 4217 code the amounts are in. It decides how many
+	// decimal places they have; empty means two, as for dollars.
+	Currency string `json:"currency,omitempty"`
+
+	// Tags are arbitrary key-value labels, such as a store region or a
+	// campaign ID, kept with the receipt for filtering. They don't affect
+	// scoring.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type Item struct {
+	ShortDescription string `json:"shortDescription" binding:"required"`
+	Price            string `json:"price" binding:"required"`
+}
+
+var commaMoneyPattern = regexp.MustCompile(`^\d+,\d{2}$`)
+
+// normalizeAmount converts an amount written with a comma decimal separator,
+// such as "35,00", to the canonical "35.00" form. Other values are returned
+// unchanged and left for validation to reject.
+func normalizeAmount(amount string) string {
+	if commaMoneyPattern.MatchString(amount) {
+		return strings.Replace(amount, ",", ".", 1)
+	}
+	return amount
+}
+
+// NormalizeRetailer trims a retailer name and collapses inner runs of
+// whitespace to one space, so names that differ only in spacing compare
+// equal.
+func NormalizeRetailer(retailer string) string {
+	return strings.Join(strings.Fields(retailer), " ")
+}
+
+// Normalize rewrites the receipt's amounts into canonical form so
+// validation and every scoring rule see the same values. A total written
+// with fewer decimal places than its currency uses, such as "35" or "35.0",
+// is padded to "35.00"; extra places are left to NormalizeTotal. A parseable
+// PurchaseDateTime fills in any empty PurchaseDate and PurchaseTime and is then
+// cleared; an unparseable one, or one that disagrees with PurchaseDate or
+// PurchaseTime, is kept for validation to reject.
+func Normalize(receipt Receipt) Receipt {
+	if receipt.PurchaseDateTime != "" {
+		if t, err := parsePurchaseDateTime(receipt.PurchaseDateTime); err == nil && !disagreesWith(receipt, t) {
+			if receipt.PurchaseDate == "" {
+				receipt.PurchaseDate = t.Format(time.DateOnly)
+			}
+			if receipt.PurchaseTime == "" {
+				receipt.PurchaseTime = t.Format("15:04")
+			}
+			receipt.PurchaseDateTime = ""
+		}
+	}
+
+	receipt.Currency = strings.ToUpper(strings.TrimSpace(receipt.Currency))
+	receipt.Total = padAmount(normalizeAmount(receipt.Total), MinorUnits(receipt.Currency))
+
+	items := make([]Item, len(receipt.Items))
+	for i, item := range receipt.Items {
+		item.Price = normalizeAmount(item.Price)
+		items[i] = item
+	}
+	receipt.Items = items
+
+	return receipt
+}
+
+// disagreesWith reports whether the receipt's PurchaseDate or PurchaseTime
+// names a different day or minute than t. Fields that are empty or don't
+// parse are left to validation.
+func disagreesWith(receipt Receipt, t time.Time) bool {
+	if date, err := parsePurchaseDate(receipt.PurchaseDate); err == nil && date.Format(time.DateOnly) != t.Format(time.DateOnly) {
+		return true
+	}
+	if minutes, err := parsePurchaseTime(receipt.PurchaseTime); err == nil && minutes != t.Hour()*60+t.Minute() {
+		return true
+	}
+	return false
+}
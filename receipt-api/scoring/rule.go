@@ -0,0 +1,411 @@
+package scoring
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rule is a single scoring rule. Calculate adds each rule's points to the
+// breakdown under its name.
+type Rule interface {
+	Name() string
+	Points(receipt Receipt) int
+}
+
+// registeredRule builds a rule from the rules config. The factory returns nil
+// when the config leaves an optional rule off.
+type registeredRule struct {
+	name    string
+	factory func(RulesConfig) Rule
+}
+
+// registry holds the rules Calculate applies, in the order they run.
+var registry []registeredRule
+
+// RegisterRule adds a rule to those Calculate applies, after the ones already
+// registered. factory is called with the rules config each time a receipt is
+// scored and may return nil to leave the rule out. It is meant to be called
+// from init functions and is not safe for concurrent use.
+func RegisterRule(name string, factory func(RulesConfig) Rule) {
+	registry = append(registry, registeredRule{name: name, factory: factory})
+}
+
+// isRegistered reports whether a rule with the given name is registered.
+func isRegistered(name string) bool {
+	for _, r := range registry {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns the rules c turns on, in the order Calculate applies them.
+// Rules named in DisabledRules are left out.
+func (c RulesConfig) Rules() []Rule {
+	rules := make([]Rule, 0, len(registry))
+	for _, r := range registry {
+		if c.isDisabled(r.name) {
+			continue
+		}
+		if rule := r.factory(c); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (c RulesConfig) isDisabled(name string) bool {
+	for _, disabled := range c.DisabledRules {
+		if disabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterRule(RuleRetailerName, func(c RulesConfig) Rule { return retailerNameRule{c.RetailerCharPoints} })
+	RegisterRule(RuleRoundDollarTotal, func(c RulesConfig) Rule { return roundDollarRule{c.RoundDollarPoints} })
+	RegisterRule(RuleQuarterTotal, func(c RulesConfig) Rule { return quarterMultipleRule{c.QuarterMultiplePoints} })
+	RegisterRule(RuleItemPairs, func(c RulesConfig) Rule { return itemPairsRule{c.ItemGroupSize, c.ItemPairPoints} })
+	RegisterRule(RuleItemDescription, func(c RulesConfig) Rule {
+		return itemDescriptionRule{c.DescriptionLengthMultiple, c.DescriptionPriceMultiplier, c.DescriptionRounding}
+	})
+	RegisterRule(RuleOddPurchaseDay, func(c RulesConfig) Rule { return purchaseDayRule{c.OddDayPoints, c.PurchaseDayMode, c.PurchaseDays} })
+	RegisterRule(RuleAfternoonPurchase, func(c RulesConfig) Rule {
+		start, end := c.afternoonWindow()
+		return afternoonRule{c.AfternoonPoints, start, end}
+	})
+	RegisterRule(RuleItemCategory, func(c RulesConfig) Rule {
+		if c.CategoryKeyword == "" {
+			return nil
+		}
+		return itemCategoryRule{strings.ToLower(c.CategoryKeyword), c.CategoryPoints}
+	})
+	RegisterRule(RuleWholeDollarItems, func(c RulesConfig) Rule {
+		if c.WholeDollarItemPoints == 0 {
+			return nil
+		}
+		return wholeDollarItemsRule{c.WholeDollarItemPoints}
+	})
+	RegisterRule(RuleItemSubtotal, func(c RulesConfig) Rule {
+		if len(c.SubtotalTiers) == 0 {
+			return nil
+		}
+		tiers := slices.Clone(c.SubtotalTiers)
+		slices.SortFunc(tiers, func(a, b SubtotalTier) int { return cmp.Compare(b.ThresholdCents, a.ThresholdCents) })
+		return itemSubtotalRule{tiers}
+	})
+	RegisterRule(RuleFuturePurchase, func(c RulesConfig) Rule {
+		if c.FutureDatePenalty == 0 {
+			return nil
+		}
+		return futurePurchaseRule{c.FutureDatePenalty, c.Now}
+	})
+	RegisterRule(RulePalindromeName, func(c RulesConfig) Rule {
+		if c.PalindromePoints == 0 {
+			return nil
+		}
+		return palindromeRetailerRule{c.PalindromePoints}
+	})
+	RegisterRule(RuleDescriptionCount, func(c RulesConfig) Rule {
+		if c.DescriptionCountPoints == 0 {
+			return nil
+		}
+		return descriptionCountRule{c.DescriptionLengthMultiple, c.DescriptionCountPoints}
+	})
+	RegisterRule(RuleLongRetailerName, func(c RulesConfig) Rule {
+		if c.LongRetailerPoints == 0 {
+			return nil
+		}
+		return longRetailerNameRule{c.LongRetailerThreshold, c.LongRetailerPoints}
+	})
+}
+
+// Rule 1: points for every alphanumeric character in the retailer name.
+type retailerNameRule struct{ points int }
+
+func (retailerNameRule) Name() string { return RuleRetailerName }
+
+func (r retailerNameRule) Points(receipt Receipt) int {
+	return alphanumericCount(receipt.Retailer) * r.points
+}
+
+// Rule 2: points if the total is a round amount with no minor units, such as
+// cents. The check is on the parsed amount rather than its spelling, so
+// "35", "35.0" and "35.00" all qualify. In a currency without minor units,
+// every total is round.
+type roundDollarRule struct{ points int }
+
+func (roundDollarRule) Name() string { return RuleRoundDollarTotal }
+
+func (r roundDollarRule) Points(receipt Receipt) int {
+	digits := MinorUnits(receipt.Currency)
+	if minor, err := parseMinorUnits(receipt.Total, digits); err == nil && minor%pow10(digits) == 0 {
+		return r.points
+	}
+	return 0
+}
+
+// Rule 3: points if the total is a multiple of a quarter of the currency's
+// major unit, 0.25 for dollars. Amounts in a currency without minor units
+// always are.
+type quarterMultipleRule struct{ points int }
+
+func (quarterMultipleRule) Name() string { return RuleQuarterTotal }
+
+func (r quarterMultipleRule) Points(receipt Receipt) int {
+	digits := MinorUnits(receipt.Currency)
+	if minor, err := parseMinorUnits(receipt.Total, digits); err == nil && minor%quarterStep(digits) == 0 {
+		return r.points
+	}
+	return 0
+}
+
+// quarterStep returns the smallest number of minor units that is a multiple
+// of a quarter of the major unit: 25 for two decimal places, 1 for none.
+func quarterStep(digits int) int64 {
+	unit := pow10(digits)
+	for _, divisor := range []int64{4, 2} {
+		if unit%divisor == 0 {
+			return unit / divisor
+		}
+	}
+	return 1
+}
+
+// Rule 4: points for every complete group of groupSize items on the
+// receipt, by default every two items. Leftover items earn nothing.
+type itemPairsRule struct{ groupSize, points int }
+
+func (itemPairsRule) Name() string { return RuleItemPairs }
+
+func (r itemPairsRule) Points(receipt Receipt) int {
+	if r.groupSize <= 0 {
+		return 0
+	}
+	return (len(receipt.Items) / r.groupSize) * r.points
+}
+
+// Rule 5: if the trimmed length of an item description is a multiple of
+// lengthMultiple, the item earns its price times priceMultiplier, rounded
+// with rounding. Prices are checked by Validate, so a receipt with an
+// unparseable price never reaches this point.
+type itemDescriptionRule struct {
+	lengthMultiple  int
+	priceMultiplier float64
+	rounding        string
+}
+
+func (itemDescriptionRule) Name() string { return RuleItemDescription }
+
+func (r itemDescriptionRule) Points(receipt Receipt) int {
+	if r.lengthMultiple <= 0 {
+		return 0
+	}
+
+	points := 0
+	for _, item := range receipt.Items {
+		if descriptionLengthIsMultiple(item, r.lengthMultiple) {
+			// ParseFloat accepts "NaN" and "Inf", which would make the
+			// conversion to int meaningless.
+			if price, err := strconv.ParseFloat(item.Price, 64); err == nil && !math.IsNaN(price) && !math.IsInf(price, 0) {
+				points += int(round(r.rounding, price*r.priceMultiplier))
+			}
+		}
+	}
+	return points
+}
+
+// descriptionLengthIsMultiple reports whether the trimmed length of item's
+// description is a multiple of multiple, which must be positive.
+func descriptionLengthIsMultiple(item Item, multiple int) bool {
+	return len(strings.TrimSpace(item.ShortDescription))%multiple == 0
+}
+
+// Rule 6: points if the day of the month in the purchase date is a bonus day
+// under mode, one of the RulesConfig.PurchaseDayMode values. Unknown modes
+// behave as PurchaseDayOdd.
+type purchaseDayRule struct {
+	points int
+	mode   string
+	days   []int
+}
+
+func (purchaseDayRule) Name() string { return RuleOddPurchaseDay }
+
+func (r purchaseDayRule) Points(receipt Receipt) int {
+	date, err := parsePurchaseDate(receipt.PurchaseDate)
+	if err != nil {
+		return 0
+	}
+
+	var bonus bool
+	switch day := date.Day(); r.mode {
+	case PurchaseDayEven:
+		bonus = day%2 == 0
+	case PurchaseDayList:
+		bonus = slices.Contains(r.days, day)
+	default:
+		bonus = day%2 == 1
+	}
+	if bonus {
+		return r.points
+	}
+	return 0
+}
+
+// Rule 7: points if the purchase time is within [start, end), in minutes
+// since midnight.
+type afternoonRule struct{ points, start, end int }
+
+func (afternoonRule) Name() string { return RuleAfternoonPurchase }
+
+func (r afternoonRule) Points(receipt Receipt) int {
+	if minutes, err := parsePurchaseTime(receipt.PurchaseTime); err == nil && minutes >= r.start && minutes < r.end {
+		return r.points
+	}
+	return 0
+}
+
+// Rule 8 (optional): points if every item description contains the
+// lowercased keyword, ignoring case.
+type itemCategoryRule struct {
+	keyword string
+	points  int
+}
+
+func (itemCategoryRule) Name() string { return RuleItemCategory }
+
+func (r itemCategoryRule) Points(receipt Receipt) int {
+	if len(receipt.Items) == 0 {
+		return 0
+	}
+	for _, item := range receipt.Items {
+		if !strings.Contains(strings.ToLower(item.ShortDescription), r.keyword) {
+			return 0
+		}
+	}
+	return r.points
+}
+
+// Rule 9 (optional): points if every item price has no minor units.
+type wholeDollarItemsRule struct{ points int }
+
+func (wholeDollarItemsRule) Name() string { return RuleWholeDollarItems }
+
+func (r wholeDollarItemsRule) Points(receipt Receipt) int {
+	digits := MinorUnits(receipt.Currency)
+	for _, item := range receipt.Items {
+		if minor, err := parseMinorUnits(item.Price, digits); err != nil || minor%pow10(digits) != 0 {
+			return 0
+		}
+	}
+	return r.points
+}
+
+// Rule 10 (optional): points for the highest tier whose threshold the sum of
+// the item prices exceeds. tiers are sorted by descending threshold.
+type itemSubtotalRule struct{ tiers []SubtotalTier }
+
+func (itemSubtotalRule) Name() string { return RuleItemSubtotal }
+
+func (r itemSubtotalRule) Points(receipt Receipt) int {
+	digits := MinorUnits(receipt.Currency)
+	var subtotal int64
+	for _, item := range receipt.Items {
+		price, err := parseMinorUnits(item.Price, digits)
+		if err != nil || subtotal > math.MaxInt64-price {
+			return 0
+		}
+		subtotal += price
+	}
+
+	for _, tier := range r.tiers {
+		if subtotal > tier.ThresholdCents {
+			return tier.Points
+		}
+	}
+	return 0
+}
+
+// Rule 11 (optional): a penalty, as negative points, if the purchase date is
+// after today's date on the now clock, or time.Now if it is nil.
+type futurePurchaseRule struct {
+	penalty int
+	now     func() time.Time
+}
+
+func (futurePurchaseRule) Name() string { return RuleFuturePurchase }
+
+func (r futurePurchaseRule) Points(receipt Receipt) int {
+	if date, err := parsePurchaseDate(receipt.PurchaseDate); err == nil && isFutureDate(date, r.now) {
+		return -r.penalty
+	}
+	return 0
+}
+
+// Rule 12 (optional): points if the retailer name is a palindrome, counting
+// the same letters and digits as rule 1 and ignoring case. A name with no
+// letters or digits doesn't count.
+type palindromeRetailerRule struct{ points int }
+
+func (palindromeRetailerRule) Name() string { return RulePalindromeName }
+
+func (r palindromeRetailerRule) Points(receipt Receipt) int {
+	var chars []rune
+	for _, char := range receipt.Retailer {
+		if isAlphanumeric(char) {
+			chars = append(chars, unicode.ToLower(char))
+		}
+	}
+	if len(chars) == 0 {
+		return 0
+	}
+	for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
+		if chars[i] != chars[j] {
+			return 0
+		}
+	}
+	return r.points
+}
+
+// Rule 13 (optional): points for every item whose trimmed description length
+// is a multiple of lengthMultiple, the same items rule 5 rewards, regardless
+// of their price.
+type descriptionCountRule struct{ lengthMultiple, points int }
+
+func (descriptionCountRule) Name() string { return RuleDescriptionCount }
+
+func (r descriptionCountRule) Points(receipt Receipt) int {
+	if r.lengthMultiple <= 0 {
+		return 0
+	}
+
+	count := 0
+	for _, item := range receipt.Items {
+		if descriptionLengthIsMultiple(item, r.lengthMultiple) {
+			count++
+		}
+	}
+	return count * r.points
+}
+
+// Rule 14 (optional): points if the retailer name has more than threshold
+// letters and digits, counted as for rule 1.
+type longRetailerNameRule struct{ threshold, points int }
+
+func (longRetailerNameRule) Name() string { return RuleLongRetailerName }
+
+func (r longRetailerNameRule) Points(receipt Receipt) int {
+	if alphanumericCount(receipt.Retailer) > r.threshold {
+		return r.points
+	}
+	return 0
+}
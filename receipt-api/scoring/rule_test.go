@@ -0,0 +1,294 @@
+package scoring
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalculatePointsExamples(t *testing.T) {
+	// The default rule set must score the challenge examples as before.
+	for _, ex := range exampleReceipts {
+		if got, err := CalculatePoints(ex.receipt); err != nil || got != ex.points {
+			t.Errorf("%s: CalculatePoints = %d, %v; want %d", ex.receipt.Retailer, got, err, ex.points)
+		}
+	}
+}
+
+func TestDisabledRulesDropOut(t *testing.T) {
+	receipt := sampleReceipt() // purchased on the 1st, an odd day
+	full := Calculate(receipt, DefaultRulesConfig())
+
+	rules := DefaultRulesConfig()
+	rules.DisabledRules = []string{RuleOddPurchaseDay}
+	breakdown := Calculate(receipt, rules)
+	if _, ok := breakdown.Rules[RuleOddPurchaseDay]; ok {
+		t.Error("disabled rule still in the breakdown")
+	}
+	if breakdown.Total != full.Total-6 {
+		t.Errorf("total = %d, want %d", breakdown.Total, full.Total-6)
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"disabledRules": ["noSuchRule"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRulesConfig(path); err == nil {
+		t.Error("unknown disabled rule accepted")
+	}
+}
+
+func TestPurchaseDayModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode string
+		days []int
+		date string
+		want int
+	}{
+		{"", nil, "2022-01-01", 6},
+		{"", nil, "2022-01-02", 0},
+		{PurchaseDayOdd, nil, "2022-01-31", 6},
+		{PurchaseDayEven, nil, "2022-01-02", 6},
+		{PurchaseDayEven, nil, "2022-01-01", 0},
+		{PurchaseDayList, []int{1, 15}, "2022-01-15", 6},
+		{PurchaseDayList, []int{1, 15}, "2022-01-02", 0},
+	} {
+		rules := DefaultRulesConfig()
+		rules.PurchaseDayMode = tt.mode
+		rules.PurchaseDays = tt.days
+		receipt := sampleReceipt()
+		receipt.PurchaseDate = tt.date
+		if got := Calculate(receipt, rules).Rules[RuleOddPurchaseDay]; got != tt.want {
+			t.Errorf("mode %q %v on %s: points = %d, want %d", tt.mode, tt.days, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestItemGroupRule(t *testing.T) {
+	for _, tt := range []struct {
+		groupSize, points, items, want int
+	}{
+		{2, 5, 1, 0},
+		{2, 5, 4, 10},
+		{2, 5, 5, 10},
+		{3, 3, 2, 0},
+		{3, 3, 5, 3},
+		{3, 3, 6, 6},
+	} {
+		rules := DefaultRulesConfig()
+		rules.ItemGroupSize = tt.groupSize
+		rules.ItemPairPoints = tt.points
+		receipt := sampleReceipt()
+		receipt.Items = make([]Item, tt.items)
+		for i := range receipt.Items {
+			receipt.Items[i] = Item{ShortDescription: "Item", Price: "1.00"}
+		}
+		if got := Calculate(receipt, rules).Rules[RuleItemPairs]; got != tt.want {
+			t.Errorf("%d items in groups of %d: points = %d, want %d", tt.items, tt.groupSize, got, tt.want)
+		}
+	}
+}
+
+func TestItemDescriptionRuleIgnoresNonFinitePrices(t *testing.T) {
+	// Validation rejects these; the rule must not turn them into points if
+	// it is ever handed one.
+	rule := itemDescriptionRule{lengthMultiple: 3, priceMultiplier: 0.2, rounding: RoundingCeil}
+	for _, price := range []string{"NaN", "Inf", "-Inf", "1e400"} {
+		receipt := sampleReceipt()
+		receipt.Items = []Item{{ShortDescription: "abc", Price: price}}
+		if got := rule.Points(receipt); got != 0 {
+			t.Errorf("price %q: points = %d, want 0", price, got)
+		}
+	}
+}
+
+func TestItemSubtotalRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.SubtotalTiers = []SubtotalTier{
+		{ThresholdCents: 5000, Points: 30},
+		{ThresholdCents: 2000, Points: 10},
+	}
+
+	for _, tt := range []struct {
+		price string
+		want  int
+	}{
+		{"19.99", 0},
+		{"20.00", 0},
+		{"20.01", 10},
+		{"50.00", 10},
+		{"50.01", 30},
+	} {
+		receipt := sampleReceipt()
+		receipt.Items = []Item{{ShortDescription: "Item", Price: tt.price}}
+		receipt.Total = tt.price
+		if got := Calculate(receipt, rules).Rules[RuleItemSubtotal]; got != tt.want {
+			t.Errorf("subtotal %s: points = %d, want %d", tt.price, got, tt.want)
+		}
+	}
+}
+
+func TestFuturePurchaseRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.FutureDatePenalty = 20
+	rules.Now = func() time.Time { return time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC) }
+
+	for _, tt := range []struct {
+		date string
+		want int
+	}{
+		{"2022-06-15", 0},
+		{"2022-06-16", -20},
+		{"2021-12-31", 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseDate = tt.date
+		if got := Calculate(receipt, rules).Rules[RuleFuturePurchase]; got != tt.want {
+			t.Errorf("date %s: points = %d, want %d", tt.date, got, tt.want)
+		}
+	}
+
+	if _, ok := Calculate(sampleReceipt(), DefaultRulesConfig()).Rules[RuleFuturePurchase]; ok {
+		t.Errorf("rule reported with no penalty configured")
+	}
+}
+
+func TestPalindromeRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.PalindromePoints = 10
+
+	for _, tt := range []struct {
+		retailer string
+		want     int
+	}{
+		{"level", 10},
+		{"Level", 10},
+		{"a,b,a", 10},
+		{"A man, a plan, a canal: Panama", 10},
+		{"Target", 0},
+		{"!!!", 0},
+	} {
+		receipt := sampleReceipt()
+		receipt.Retailer = tt.retailer
+		if got := Calculate(receipt, rules).Rules[RulePalindromeName]; got != tt.want {
+			t.Errorf("%q: points = %d, want %d", tt.retailer, got, tt.want)
+		}
+	}
+}
+
+func TestDescriptionCountRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.DescriptionCountPoints = 5
+	rules.DisabledRules = []string{RuleItemDescription}
+
+	receipt := sampleReceipt()
+	receipt.Items = []Item{
+		{ShortDescription: "abc", Price: "1.00"},
+		{ShortDescription: "  abcdef ", Price: "100.00"},
+		{ShortDescription: "abcd", Price: "1.00"},
+	}
+	breakdown := Calculate(receipt, rules)
+	if got := breakdown.Rules[RuleDescriptionCount]; got != 10 {
+		t.Errorf("description count points = %d, want 10", got)
+	}
+	if _, ok := breakdown.Rules[RuleItemDescription]; ok {
+		t.Errorf("disabled price bonus still in the breakdown")
+	}
+}
+
+func TestLongRetailerNameRule(t *testing.T) {
+	rules := DefaultRulesConfig()
+	rules.LongRetailerThreshold = 10
+	rules.LongRetailerPoints = 10
+
+	for _, tt := range []struct {
+		retailer string
+		want     int
+	}{
+		{"Walgreens", 0},      // 9 characters, below
+		{"Walgreens 1", 0},    // 10, at the threshold
+		{"Walgreens #12", 10}, // 11, above
+	} {
+		receipt := sampleReceipt()
+		receipt.Retailer = tt.retailer
+		breakdown := Calculate(receipt, rules)
+		if got := breakdown.Rules[RuleLongRetailerName]; got != tt.want {
+			t.Errorf("%q: long retailer points = %d, want %d", tt.retailer, got, tt.want)
+		}
+		if got, want := breakdown.Rules[RuleRetailerName], alphanumericCount(tt.retailer); got != want {
+			t.Errorf("%q: retailer name points = %d, want %d", tt.retailer, got, want)
+		}
+	}
+}
+
+func TestLongRetailerNameRuleToggles(t *testing.T) {
+	receipt := sampleReceipt()
+	receipt.Retailer = "Walgreens #12"
+
+	if _, ok := Calculate(receipt, DefaultRulesConfig()).Rules[RuleLongRetailerName]; ok {
+		t.Error("long retailer rule runs by default")
+	}
+
+	rules := DefaultRulesConfig()
+	rules.LongRetailerThreshold = 10
+	rules.LongRetailerPoints = 10
+
+	// Each rule can be turned off without the other.
+	for _, disabled := range []string{RuleRetailerName, RuleLongRetailerName} {
+		rules.DisabledRules = []string{disabled}
+		breakdown := Calculate(receipt, rules)
+		if _, ok := breakdown.Rules[disabled]; ok {
+			t.Errorf("disabling %s left it in the breakdown", disabled)
+		}
+		other := RuleLongRetailerName
+		if disabled == RuleLongRetailerName {
+			other = RuleRetailerName
+		}
+		if breakdown.Rules[other] == 0 {
+			t.Errorf("disabling %s also dropped %s", disabled, other)
+		}
+	}
+	if err := rules.validate(); err != nil {
+		t.Errorf("disabling longRetailerName: %v", err)
+	}
+}
+
+func TestRulesConfigRejectsUnknownFields(t *testing.T) {
+	if _, err := ParseRulesConfig([]byte(`{"roundDollarPoints": 40}`)); err != nil {
+		t.Errorf("known field: %v", err)
+	}
+	if _, err := ParseRulesConfig([]byte(`{"roundDolarPoints": 40}`)); err == nil {
+		t.Error("misspelled field accepted")
+	}
+	for _, data := range []string{``, `{"roundDollarPoints": 40} {}`, `{`} {
+		var syntaxErr *json.SyntaxError
+		if _, err := ParseRulesConfig([]byte(data)); !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: err = %v, want a syntax error", data, err)
+		}
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"rules.json": `{"roundDolarPoints": 40}`,
+		"rules.yaml": "roundDolarPoints: 40\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRulesConfig(path); err == nil {
+			t.Errorf("%s: misspelled field accepted", name)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.yaml")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRulesConfig(empty); err != nil {
+		t.Errorf("empty YAML file: %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package scoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RulesConfig holds the point values used by Calculate. Fields left
+// out of a rules file keep their default values.
+type RulesConfig struct {
+	// Points per alphanumeric character in the retailer name.
+	RetailerCharPoints int `json:"retailerCharPoints" yaml:"retailerCharPoints"`
+
+	// LongRetailerPoints are a bonus for retailer names with more than
+	// LongRetailerThreshold letters and digits, counted as for
+	// RetailerCharPoints. The rule is off when zero.
+	LongRetailerThreshold int `json:"longRetailerThreshold" yaml:"longRetailerThreshold"`
+	LongRetailerPoints    int `json:"longRetailerPoints" yaml:"longRetailerPoints"`
+
+	// Points when the total is a round dollar amount.
+	RoundDollarPoints int `json:"roundDollarPoints" yaml:"roundDollarPoints"`
+
+	// Points when the total is a multiple of 0.25.
+	QuarterMultiplePoints int `json:"quarterMultiplePoints" yaml:"quarterMultiplePoints"`
+
+	// ItemPairPoints are awarded for every complete group of ItemGroupSize
+	// items on the receipt. The breakdown keeps the itemPairs name whatever
+	// the group size.
+	ItemPairPoints int `json:"itemPairPoints" yaml:"itemPairPoints"`
+	ItemGroupSize  int `json:"itemGroupSize" yaml:"itemGroupSize"`
+
+	// Items whose trimmed description length is a multiple of
+	// DescriptionLengthMultiple earn their price times DescriptionPriceMultiplier,
+	// rounded according to DescriptionRounding: RoundingCeil, RoundingFloor or
+	// RoundingRound (half away from zero). Empty means RoundingCeil.
+	DescriptionLengthMultiple  int     `json:"descriptionLengthMultiple" yaml:"descriptionLengthMultiple"`
+	DescriptionPriceMultiplier float64 `json:"descriptionPriceMultiplier" yaml:"descriptionPriceMultiplier"`
+	DescriptionRounding        string  `json:"descriptionRounding" yaml:"descriptionRounding"`
+
+	// DescriptionCountPoints are a flat alternative to the price bonus above:
+	// points for every item whose trimmed description length is a multiple
+	// of DescriptionLengthMultiple. The rule is off when zero. To use it
+	// instead of the price bonus, add "itemDescription" to DisabledRules.
+	DescriptionCountPoints int `json:"descriptionCountPoints" yaml:"descriptionCountPoints"`
+
+	// OddDayPoints are awarded when the day of the month in the purchase
+	// date is a bonus day. PurchaseDayMode picks the bonus days:
+	// PurchaseDayOdd (the default when empty), PurchaseDayEven, or
+	// PurchaseDayList for the days listed in PurchaseDays. The breakdown
+	// keeps the oddPurchaseDay name whichever mode is used.
+	OddDayPoints    int    `json:"oddDayPoints" yaml:"oddDayPoints"`
+	PurchaseDayMode string `json:"purchaseDayMode" yaml:"purchaseDayMode"`
+	PurchaseDays    []int  `json:"purchaseDays" yaml:"purchaseDays"`
+
+	// AfternoonPoints are awarded when the purchase time is at or after
+	// AfternoonStart and before AfternoonEnd, both written as 24-hour "HH:MM".
+	AfternoonPoints int    `json:"afternoonPoints" yaml:"afternoonPoints"`
+	AfternoonStart  string `json:"afternoonStart" yaml:"afternoonStart"`
+	AfternoonEnd    string `json:"afternoonEnd" yaml:"afternoonEnd"`
+
+	// CategoryPoints are awarded when every item description contains
+	// CategoryKeyword, ignoring case. The rule is off when the keyword is
+	// empty.
+	CategoryKeyword string `json:"categoryKeyword" yaml:"categoryKeyword"`
+	CategoryPoints  int    `json:"categoryPoints" yaml:"categoryPoints"`
+
+	// Points when every item price is a whole-dollar amount. The rule is off
+	// when zero.
+	WholeDollarItemPoints int `json:"wholeDollarItemPoints" yaml:"wholeDollarItemPoints"`
+
+	// SubtotalTiers award points when the sum of the item prices exceeds a
+	// threshold. Only the highest tier reached counts. The rule is off when
+	// no tiers are listed.
+	SubtotalTiers []SubtotalTier `json:"subtotalTiers" yaml:"subtotalTiers"`
+
+	// PalindromePoints are awarded when the retailer name reads the same
+	// backwards, counting only its letters and digits and ignoring case, so
+	// "A,b,a" qualifies. The rule is off when zero.
+	PalindromePoints int `json:"palindromePoints" yaml:"palindromePoints"`
+
+	// FutureDatePenalty points are taken off receipts whose purchase date is
+	// after today on the Now clock, which defaults to time.Now. The rule is
+	// off when zero. A penalty larger than the other rules' points leaves the
+	// total negative. To reject such receipts instead, use
+	// Limits.RejectFutureDates.
+	FutureDatePenalty int              `json:"futureDatePenalty" yaml:"futureDatePenalty"`
+	Now               func() time.Time `json:"-" yaml:"-"`
+
+	// BatchRetailerPoints are awarded to a batch submission whose stored
+	// receipts span at least BatchRetailerThreshold distinct retailers. They
+	// are reported in the batch response's X-Batch-Bonus header and don't
+	// change any receipt's points. The rule is off when zero.
+	BatchRetailerThreshold int `json:"batchRetailerThreshold" yaml:"batchRetailerThreshold"`
+	BatchRetailerPoints    int `json:"batchRetailerPoints" yaml:"batchRetailerPoints"`
+
+	// DisabledRules names rules, such as "oddPurchaseDay", that are skipped
+	// entirely and left out of the breakdown.
+	DisabledRules []string `json:"disabledRules" yaml:"disabledRules"`
+
+	// GlobalMultiplier scales a receipt's total, e.g. 2 for a double-points
+	// promotion, rounded according to DescriptionRounding. The change is
+	// recorded in the breakdown under globalMultiplier. Zero or one leaves
+	// totals unchanged.
+	GlobalMultiplier float64 `json:"globalMultiplier" yaml:"globalMultiplier"`
+
+	// MaxPoints caps the total points a receipt can earn. Zero means no cap.
+	// It applies on top of the server's RECEIPT_API_MAX_POINTS limit.
+	MaxPoints int `json:"maxPoints" yaml:"maxPoints"`
+
+	// The afternoon window in minutes since midnight, parsed from the
+	// AfternoonStart and AfternoonEnd values recorded in afternoonParsedFrom.
+	afternoonStart, afternoonEnd int
+	afternoonParsedFrom          [2]string
+}
+
+// SubtotalTier is one step of the item subtotal rule: Points are awarded when
+// the item prices sum to more than ThresholdCents, in the currency's minor
+// units.
+type SubtotalTier struct {
+	ThresholdCents int64 `json:"thresholdCents" yaml:"thresholdCents"`
+	Points         int   `json:"points" yaml:"points"`
+}
+
+// parseAfternoonWindow parses and checks AfternoonStart and AfternoonEnd.
+func (c *RulesConfig) parseAfternoonWindow() error {
+	start, err := time.Parse("15:04", c.AfternoonStart)
+	if err != nil {
+		return errors.New("afternoonStart must be a time in HH:MM format")
+	}
+	end, err := time.Parse("15:04", c.AfternoonEnd)
+	if err != nil {
+		return errors.New("afternoonEnd must be a time in HH:MM format")
+	}
+	if !start.Before(end) {
+		return errors.New("afternoonStart must be before afternoonEnd")
+	}
+
+	c.afternoonStart = start.Hour()*60 + start.Minute()
+	c.afternoonEnd = end.Hour()*60 + end.Minute()
+	c.afternoonParsedFrom = [2]string{c.AfternoonStart, c.AfternoonEnd}
+	return nil
+}
+
+// afternoonWindow returns the afternoon window in minutes since midnight.
+// Times changed since the config was loaded, or set on a config built field by
+// field, are parsed on each call, falling back to the default 2:00-4:00 PM
+// window if they are invalid.
+func (c RulesConfig) afternoonWindow() (start, end int) {
+	if c.afternoonParsedFrom != [2]string{c.AfternoonStart, c.AfternoonEnd} {
+		if err := c.parseAfternoonWindow(); err != nil {
+			return 14 * 60, 16 * 60
+		}
+	}
+	return c.afternoonStart, c.afternoonEnd
+}
+
+// Modes for RulesConfig.PurchaseDayMode.
+const (
+	PurchaseDayOdd  = "odd"
+	PurchaseDayEven = "even"
+	PurchaseDayList = "list"
+)
+
+// Rounding modes for RulesConfig.DescriptionRounding.
+const (
+	RoundingCeil  = "ceil"
+	RoundingFloor = "floor"
+	RoundingRound = "round"
+)
+
+// round applies the rounding mode to value. Unknown modes round up.
+func round(mode string, value float64) float64 {
+	switch mode {
+	case RoundingFloor:
+		return math.Floor(value)
+	case RoundingRound:
+		return math.Round(value)
+	default:
+		return math.Ceil(value)
+	}
+}
+
+func DefaultRulesConfig() RulesConfig {
+	config := RulesConfig{
+		RetailerCharPoints:         1,
+		RoundDollarPoints:          50,
+		QuarterMultiplePoints:      25,
+		ItemPairPoints:             5,
+		ItemGroupSize:              2,
+		DescriptionLengthMultiple:  3,
+		DescriptionPriceMultiplier: 0.2,
+		DescriptionRounding:        RoundingCeil,
+		OddDayPoints:               6,
+		PurchaseDayMode:            PurchaseDayOdd,
+		AfternoonPoints:            10,
+		AfternoonStart:             "14:00",
+		AfternoonEnd:               "16:00",
+		GlobalMultiplier:           1,
+	}
+	config.parseAfternoonWindow()
+	return config
+}
+
+// LoadRulesConfig reads a rules file on top of the defaults. Files ending in
+// .yaml or .yml are parsed as YAML, anything else as JSON; unknown settings
+// are rejected so a misspelled rule doesn't silently keep its default. An
+// empty path returns the defaults.
+func LoadRulesConfig(path string) (RulesConfig, error) {
+	config := DefaultRulesConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RulesConfig{}, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file sets nothing.
+		if err = decoder.Decode(&config); err == io.EOF {
+			err = nil
+		}
+	default:
+		err = decodeRulesJSON(data, &config)
+	}
+	if err != nil {
+		return RulesConfig{}, err
+	}
+	if err := config.validate(); err != nil {
+		return RulesConfig{}, err
+	}
+	return config, nil
+}
+
+// ParseRulesConfig parses a JSON rules config on top of the defaults, checking
+// it as LoadRulesConfig does.
+func ParseRulesConfig(data []byte) (RulesConfig, error) {
+	config := DefaultRulesConfig()
+	if err := decodeRulesJSON(data, &config); err != nil {
+		return RulesConfig{}, err
+	}
+	if err := config.validate(); err != nil {
+		return RulesConfig{}, err
+	}
+	return config, nil
+}
+
+// decodeRulesJSON decodes a single JSON rules config into config, rejecting
+// unknown fields. Like json.Unmarshal, it reports empty input and trailing
+// data as syntax errors.
+func decodeRulesJSON(data []byte, config *RulesConfig) error {
+	if err := json.Unmarshal(data, new(json.RawMessage)); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// validate checks the values a rules file may set, parsing the afternoon
+// window.
+func (c *RulesConfig) validate() error {
+	if c.ItemGroupSize < 1 {
+		return errors.New("itemGroupSize must be at least 1")
+	}
+	switch c.DescriptionRounding {
+	case "", RoundingCeil, RoundingFloor, RoundingRound:
+	default:
+		return errors.New("descriptionRounding must be \"ceil\", \"floor\" or \"round\"")
+	}
+	switch c.PurchaseDayMode {
+	case "", PurchaseDayOdd, PurchaseDayEven:
+	case PurchaseDayList:
+		if len(c.PurchaseDays) == 0 {
+			return errors.New("purchaseDays must list at least one day when purchaseDayMode is \"list\"")
+		}
+		for _, day := range c.PurchaseDays {
+			if day < 1 || day > 31 {
+				return errors.New("purchaseDays must be days of the month between 1 and 31")
+			}
+		}
+	default:
+		return errors.New("purchaseDayMode must be \"odd\", \"even\" or \"list\"")
+	}
+	if err := c.parseAfternoonWindow(); err != nil {
+		return err
+	}
+	if c.LongRetailerThreshold < 0 {
+		return errors.New("longRetailerThreshold must not be negative")
+	}
+	if c.FutureDatePenalty < 0 {
+		return errors.New("futureDatePenalty must not be negative")
+	}
+	for _, tier := range c.SubtotalTiers {
+		if tier.ThresholdCents < 0 {
+			return errors.New("subtotalTiers: thresholdCents must not be negative")
+		}
+	}
+	if c.GlobalMultiplier < 0 || math.IsNaN(c.GlobalMultiplier) || math.IsInf(c.GlobalMultiplier, 0) {
+		return errors.New("globalMultiplier must be a non-negative number")
+	}
+	for _, name := range c.DisabledRules {
+		if !isRegistered(name) {
+			return errors.New("disabledRules: unknown rule " + strconv.Quote(name))
+		}
+	}
+	return nil
+}
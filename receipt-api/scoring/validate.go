@@ -0,0 +1,243 @@
+package scoring
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits bounds the size of receipts the service accepts and the points it
+// will award, protecting it from oversized payloads.
+type Limits struct {
+	MaxItems  int
+	MaxPoints int
+
+	// MaxTotalCents is the largest total accepted, in the currency's minor
+	// units. Zero leaves only the limit of what can be represented.
+	MaxTotalCents int64
+
+	// CheckItemTotal rejects receipts whose total differs from the sum of
+	// their item prices by more than TotalToleranceCents, counted in the
+	// currency's minor units. It is off by default because totals often
+	// include unitemized tax or tips.
+	CheckItemTotal      bool
+	TotalToleranceCents int64
+
+	// RoundTotal makes NormalizeTotal round totals with more decimal places
+	// than the currency uses, such as "14.255", to exactly that many. When it
+	// is off, Validate rejects them. Totals with fewer places, such as
+	// "14.2", are always padded by Normalize.
+	RoundTotal bool
+
+	// RejectFutureDates rejects receipts whose purchase date is after today
+	// by the server's clock. Today itself is accepted.
+	RejectFutureDates bool
+
+	// Now is the clock RejectFutureDates checks against. It defaults to
+	// time.Now; tests can pin it.
+	Now func() time.Time
+}
+
+const (
+	DefaultMaxItems      = 1000
+	DefaultMaxPoints     = 1000000
+	DefaultMaxTotalCents = 100000000 // 1,000,000.00
+)
+
+func DefaultLimits() Limits {
+	return Limits{MaxItems: DefaultMaxItems, MaxPoints: DefaultMaxPoints, MaxTotalCents: DefaultMaxTotalCents}
+}
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors lists every problem Validate found with a receipt, in the
+// order of the receipt's fields.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap lets errors.As find the individual *ValidationError values.
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Validate checks that a normalized receipt is well formed and within limits.
+// Every failure is reported, as ValidationErrors naming the offending fields;
+// item fields carry their index, e.g. "items[2].price".
+func Validate(receipt Receipt, limits Limits) error {
+	var errs ValidationErrors
+	fail := func(field, message string) {
+		errs = append(errs, &ValidationError{Field: field, Message: message})
+	}
+
+	if strings.TrimSpace(receipt.Retailer) == "" {
+		fail("retailer", "must not be empty")
+	}
+	if receipt.PurchaseDateTime != "" {
+		// Normalize only keeps a parseable one that disagrees with the others.
+		if _, err := parsePurchaseDateTime(receipt.PurchaseDateTime); err != nil {
+			fail("purchaseDateTime", "must be an ISO 8601 date and time, e.g. \"2022-01-01T13:01:00Z\"")
+		} else {
+			fail("purchaseDateTime", "must agree with purchaseDate and purchaseTime when they are also given")
+		}
+	} else {
+		if date, err := parsePurchaseDate(receipt.PurchaseDate); err != nil {
+			fail("purchaseDate", "must be a valid date in YYYY-MM-DD format")
+		} else if limits.RejectFutureDates && isFutureDate(date, limits.Now) {
+			fail("purchaseDate", "must not be in the future")
+		}
+		if _, err := parsePurchaseTime(receipt.PurchaseTime); err != nil {
+			fail("purchaseTime", "must be in HH:MM, HH:MM:SS or h:MM AM/PM format")
+		}
+	}
+	if receipt.Currency != "" && !currencyPattern.MatchString(receipt.Currency) {
+		fail("currency", "must be a three-letter ISO 4217 currency code, e.g. \"USD\"")
+	}
+	for key := range receipt.Tags {
+		if key == "" || strings.Contains(key, ":") {
+			fail("tags", "keys must not be empty or contain \":\"")
+			break
+		}
+	}
+	digits := MinorUnits(receipt.Currency)
+	if !isAmount(receipt.Total, digits) {
+		fail("total", amountMessage(receipt.Currency, digits, "35.00"))
+	} else if total, err := parseMinorUnits(receipt.Total, digits); err != nil {
+		fail("total", "is too large")
+	} else if limits.MaxTotalCents > 0 && total > limits.MaxTotalCents {
+		fail("total", "must be at most "+formatMinorUnits(limits.MaxTotalCents, digits))
+	}
+
+	switch {
+	case len(receipt.Items) == 0:
+		fail("items", "receipt must contain at least one item")
+	case limits.MaxItems > 0 && len(receipt.Items) > limits.MaxItems:
+		// The items of an oversized receipt aren't checked, so the response
+		// stays small too.
+		fail("items", "must contain at most "+strconv.Itoa(limits.MaxItems)+" items")
+	default:
+		for i, item := range receipt.Items {
+			field := "items[" + strconv.Itoa(i) + "].price"
+			if item.Price == "" {
+				fail(field, "must not be empty")
+			} else if !isAmount(item.Price, digits) {
+				fail(field, amountMessage(receipt.Currency, digits, "6.49"))
+			} else if _, err := parseMinorUnits(item.Price, digits); err != nil {
+				fail(field, "is too large")
+			}
+		}
+	}
+
+	// The items can only be summed once every amount is known to be valid.
+	if limits.CheckItemTotal && len(errs) == 0 {
+		if err := checkItemTotal(receipt, limits.TotalToleranceCents); err != nil {
+			fail(err.Field, err.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// NormalizeTotal rewrites a normalized receipt's total to exactly the
+// currency's number of decimal places when limits.RoundTotal is set, rounding
+// extra places half up, so "14.255" becomes "14.26". Missing places have
+// already been filled in by Normalize. Totals that aren't decimal numbers are
+// left for Validate to reject.
+func NormalizeTotal(receipt Receipt, limits Limits) Receipt {
+	if limits.RoundTotal {
+		receipt.Total = roundAmount(receipt.Total, MinorUnits(receipt.Currency))
+	}
+	return receipt
+}
+
+// checkItemTotal compares the total with the sum of the item prices, both of
+// which have already been checked with isAmount.
+func checkItemTotal(receipt Receipt, toleranceCents int64) *ValidationError {
+	digits := MinorUnits(receipt.Currency)
+	total, err := parseMinorUnits(receipt.Total, digits)
+	if err != nil {
+		return &ValidationError{Field: "total", Message: "must be a valid amount"}
+	}
+
+	var sum int64
+	for i, item := range receipt.Items {
+		price, err := parseMinorUnits(item.Price, digits)
+		if err != nil || sum > math.MaxInt64-price {
+			return &ValidationError{Field: "items[" + strconv.Itoa(i) + "].price", Message: "must be a valid amount"}
+		}
+		sum += price
+	}
+
+	if diff := total - sum; diff > toleranceCents || -diff > toleranceCents {
+		return &ValidationError{Field: "total", Message: "must match the sum of the item prices, " + formatMinorUnits(sum, digits)}
+	}
+	return nil
+}
+
+// isFutureDate reports whether date, a purchase date at midnight UTC, falls
+// after today's date on the given clock, or time.Now if it is nil.
+func isFutureDate(date time.Time, now func() time.Time) bool {
+	if now == nil {
+		now = time.Now
+	}
+	today, _ := time.Parse(time.DateOnly, now().Format(time.DateOnly))
+	return date.After(today)
+}
+
+// parsePurchaseDate parses a YYYY-MM-DD purchase date, rejecting dates that
+// don't exist such as 2022-02-30.
+func parsePurchaseDate(value string) (time.Time, error) {
+	return time.Parse(time.DateOnly, value)
+}
+
+// purchaseDateTimeLayouts are the purchaseDateTime formats accepted, tried in
+// order. A UTC offset is optional; the local time as written is what's scored.
+var purchaseDateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// parsePurchaseDateTime parses an ISO 8601 purchase date and time in any of
+// purchaseDateTimeLayouts.
+func parsePurchaseDateTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range purchaseDateTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognized date and time format: " + value)
+}
+
+// purchaseTimeLayouts are the purchaseTime formats accepted, tried in order.
+var purchaseTimeLayouts = []string{"15:04", "15:04:05", "3:04 PM", "3:04PM", "3:04:05 PM"}
+
+// parsePurchaseTime parses a purchase time in any of purchaseTimeLayouts and
+// returns it as minutes since midnight.
+func parsePurchaseTime(value string) (int, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	for _, layout := range purchaseTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Hour()*60 + t.Minute(), nil
+		}
+	}
+	return 0, errors.New("unrecognized time format: " + value)
+}
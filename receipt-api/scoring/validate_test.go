@@ -0,0 +1,267 @@
+package scoring
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// invalidFields returns the fields Validate reports for receipt, or nil if it
+// is valid.
+func invalidFields(t *testing.T, receipt Receipt, limits Limits) []string {
+	t.Helper()
+	err := Validate(receipt, limits)
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate returned %T, want ValidationErrors", err)
+	}
+	fields := make([]string, len(errs))
+	for i, fe := range errs {
+		fields[i] = fe.Field
+	}
+	return fields
+}
+
+func TestValidateItemPrices(t *testing.T) {
+	for _, tt := range []struct {
+		price   string
+		message string
+	}{
+		{"", "must not be empty"},
+		{"abc", "must be a dollar amount with two decimal places, e.g. \"6.49\""},
+		{"6.4", "must be a dollar amount with two decimal places, e.g. \"6.49\""},
+		{"NaN", "must be a dollar amount with two decimal places, e.g. \"6.49\""},
+	} {
+		receipt := sampleReceipt()
+		receipt.Items[1].Price = tt.price
+		err := Validate(Normalize(receipt), DefaultLimits())
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "items[1].price" || errs[0].Message != tt.message {
+			t.Errorf("price %q: Validate = %v, want items[1].price: %s", tt.price, err, tt.message)
+		}
+	}
+}
+
+func TestValidatePurchaseTime(t *testing.T) {
+	for _, tt := range []struct {
+		time  string
+		valid bool
+	}{
+		{"14:30", true},
+		{"14:30:00", true},
+		{"2:30 PM", true},
+		{"2:30PM", true},
+		{"2:30 pm", true},
+		{"25:00", false},
+		{"14", false},
+		{"2:30 XM", false},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseTime = tt.time
+		fields := invalidFields(t, receipt, DefaultLimits())
+		if got := slices.Contains(fields, "purchaseTime"); got == tt.valid {
+			t.Errorf("time %q: invalid fields %v, want valid %v", tt.time, fields, tt.valid)
+		}
+	}
+}
+
+func TestValidateItemsRequired(t *testing.T) {
+	for _, items := range []string{`null`, `[]`, ``} {
+		body := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","total":"1.00"`
+		if items != "" {
+			body += `,"items":` + items
+		}
+		var receipt Receipt
+		if err := json.Unmarshal([]byte(body+"}"), &receipt); err != nil {
+			t.Fatal(err)
+		}
+		err := Validate(Normalize(receipt), DefaultLimits())
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "items" || errs[0].Message != "receipt must contain at least one item" {
+			t.Errorf("items %q: Validate = %v", items, err)
+		}
+	}
+}
+
+func TestValidatePurchaseDate(t *testing.T) {
+	for _, tt := range []struct {
+		date  string
+		valid bool
+	}{
+		{"2022-02-28", true},
+		{"2024-02-29", true},
+		{"2022-02-30", false},
+		{"2022-02-29", false},
+		{"2022-13-01", false},
+		{"2022-04-31", false},
+		{"2022-1-1", false},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseDate = tt.date
+		fields := invalidFields(t, receipt, DefaultLimits())
+		if got := slices.Contains(fields, "purchaseDate"); got == tt.valid {
+			t.Errorf("date %q: invalid fields %v, want valid %v", tt.date, fields, tt.valid)
+		}
+	}
+}
+
+func TestValidateItemTotal(t *testing.T) {
+	limits := DefaultLimits()
+	limits.CheckItemTotal = true
+
+	receipt := sampleReceipt() // items sum to 18.74
+	if err := Validate(receipt, limits); err != nil {
+		t.Errorf("matching total rejected: %v", err)
+	}
+	receipt.Total = "18.75"
+	if err := Validate(receipt, limits); err == nil {
+		t.Error("total one cent off accepted with no tolerance")
+	}
+	limits.TotalToleranceCents = 1
+	if err := Validate(receipt, limits); err != nil {
+		t.Errorf("total within tolerance rejected: %v", err)
+	}
+}
+
+func TestValidateReportsEveryFailure(t *testing.T) {
+	receipt := sampleReceipt()
+	receipt.Retailer = " "
+	receipt.PurchaseDate = "2022-02-30"
+	receipt.Items[0].Price = "abc"
+	receipt.Items[1].Price = ""
+
+	want := []string{"retailer", "purchaseDate", "items[0].price", "items[1].price"}
+	if fields := invalidFields(t, receipt, DefaultLimits()); !slices.Equal(fields, want) {
+		t.Errorf("invalid fields = %v, want %v", fields, want)
+	}
+}
+
+func TestValidateTotal(t *testing.T) {
+	for _, tt := range []struct {
+		total string
+		valid bool
+	}{
+		{"35.00", true},
+		{"35", true},
+		{"35.00", true},
+		{"35", true},
+		{"1000000.00", true},
+		{"1000000.01", false},
+		{"999999999999999999999.00", false},
+		{"NaN", false},
+		{"Inf", false},
+		{"+Inf", false},
+		{"-1.00", false},
+		{"1e3", false},
+	} {
+		receipt := sampleReceipt()
+		receipt.Total = tt.total
+		fields := invalidFields(t, Normalize(receipt), DefaultLimits())
+		if got := slices.Contains(fields, "total"); got == tt.valid {
+			t.Errorf("total %q: invalid fields %v, want valid %v", tt.total, fields, tt.valid)
+		}
+	}
+
+	// Without the configured maximum, only what fits in 64 bits is accepted.
+	receipt := sampleReceipt()
+	receipt.Total = "999999999999999999999.00"
+	if fields := invalidFields(t, receipt, Limits{}); !slices.Contains(fields, "total") {
+		t.Errorf("overflowing total accepted with no maximum")
+	}
+}
+
+func TestNormalizeTotalPrecision(t *testing.T) {
+	for _, tt := range []struct {
+		total string
+		round bool
+		want  string
+		valid bool
+	}{
+		{"14.25", false, "14.25", true},
+		{"14.2", false, "14.20", true},
+		{"14.255", false, "14.255", false},
+		{"14.25", true, "14.25", true},
+		{"14.2", true, "14.20", true},
+		{"14.250", true, "14.25", true},
+		{"14.255", true, "14.26", true},
+		{"14.254", true, "14.25", true},
+		{"abc", true, "abc", false},
+	} {
+		limits := DefaultLimits()
+		limits.RoundTotal = tt.round
+		receipt := sampleReceipt()
+		receipt.Total = tt.total
+		receipt = NormalizeTotal(Normalize(receipt), limits)
+		if receipt.Total != tt.want {
+			t.Errorf("total %q (round %v) = %q, want %q", tt.total, tt.round, receipt.Total, tt.want)
+		}
+		fields := invalidFields(t, receipt, limits)
+		if got := slices.Contains(fields, "total"); got == tt.valid {
+			t.Errorf("total %q (round %v): invalid fields %v, want valid %v", tt.total, tt.round, fields, tt.valid)
+		}
+	}
+}
+
+func TestValidateFutureDates(t *testing.T) {
+	now := func() time.Time { return time.Date(2022, 6, 15, 23, 59, 0, 0, time.UTC) }
+	for _, tt := range []struct {
+		date   string
+		reject bool
+		valid  bool
+	}{
+		{"2022-06-14", true, true},
+		{"2022-06-15", true, true},
+		{"2022-06-16", true, false},
+		{"2023-01-01", true, false},
+		{"2022-06-16", false, true},
+	} {
+		limits := DefaultLimits()
+		limits.RejectFutureDates = tt.reject
+		limits.Now = now
+		receipt := sampleReceipt()
+		receipt.PurchaseDate = tt.date
+		fields := invalidFields(t, receipt, limits)
+		if got := slices.Contains(fields, "purchaseDate"); got == tt.valid {
+			t.Errorf("date %s (reject %v): invalid fields %v, want valid %v", tt.date, tt.reject, fields, tt.valid)
+		}
+	}
+}
+
+func TestNormalizePurchaseDateTime(t *testing.T) {
+	for _, tt := range []struct {
+		name               string
+		dateTime           string
+		date, time         string
+		invalid            []string
+		wantDate, wantTime string
+	}{
+		{name: "alone", dateTime: "2022-01-01T13:01:00Z", wantDate: "2022-01-01", wantTime: "13:01"},
+		{name: "offset", dateTime: "2022-01-01T13:01:00-05:00", wantDate: "2022-01-01", wantTime: "13:01"},
+		{name: "no seconds", dateTime: "2022-01-01T13:01", wantDate: "2022-01-01", wantTime: "13:01"},
+		{name: "agreeing", dateTime: "2022-01-01T13:01:00Z", date: "2022-01-01", time: "1:01 PM", wantDate: "2022-01-01", wantTime: "1:01 PM"},
+		{name: "date only agreeing", dateTime: "2022-01-01T13:01:00Z", date: "2022-01-01", wantDate: "2022-01-01", wantTime: "13:01"},
+		{name: "unparseable", dateTime: "01/01/2022 13:01", invalid: []string{"purchaseDateTime"}},
+		{name: "conflicting date", dateTime: "2022-01-02T13:01:00Z", date: "2022-01-01", time: "13:01", invalid: []string{"purchaseDateTime"}},
+		{name: "conflicting time", dateTime: "2022-01-01T14:01:00Z", date: "2022-01-01", time: "13:01", invalid: []string{"purchaseDateTime"}},
+		{name: "conflicting time alone", dateTime: "2022-01-01T14:01:00Z", time: "13:01", invalid: []string{"purchaseDateTime"}},
+		// A malformed date is reported as such rather than as a conflict.
+		{name: "invalid date", dateTime: "2022-01-01T13:01:00Z", date: "2022-13-01", invalid: []string{"purchaseDate"}},
+	} {
+		receipt := sampleReceipt()
+		receipt.PurchaseDateTime, receipt.PurchaseDate, receipt.PurchaseTime = tt.dateTime, tt.date, tt.time
+		receipt = Normalize(receipt)
+
+		fields := invalidFields(t, receipt, DefaultLimits())
+		if !slices.Equal(fields, tt.invalid) {
+			t.Errorf("%s: invalid fields %v, want %v", tt.name, fields, tt.invalid)
+		}
+		if tt.invalid == nil && (receipt.PurchaseDate != tt.wantDate || receipt.PurchaseTime != tt.wantTime || receipt.PurchaseDateTime != "") {
+			t.Errorf("%s: normalized to %q %q %q, want %q %q", tt.name, receipt.PurchaseDate, receipt.PurchaseTime, receipt.PurchaseDateTime, tt.wantDate, tt.wantTime)
+		}
+	}
+}
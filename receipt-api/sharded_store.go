@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sync/atomic"
+
+	"receipt-api/scoring"
+)
+
+// ShardedStore is an in-memory Store that spreads receipts over several
+// ReceiptStores, each with its own lock, so concurrent requests for different
+// receipts rarely contend. A receipt's shard is derived from a hash of its ID.
+//
+// Receipts with an idempotency key are routed by the key, and otherwise with
+// deduplication on by their content, so concurrent submissions of either land
+// on one shard and are handled as by a single ReceiptStore. Before a receipt
+// is added, every shard is asked for a receipt with the same key, then for
+// one with the same content, so replays and duplicates stored on other shards
+// are found too. Only duplicates submitted at the same moment under different
+// keys, or one with a key and one without, may both be stored.
+type ShardedStore struct {
+	shards []*ReceiptStore
+	dedup  bool
+	next   atomic.Uint64 // round-robin counter for receipts with no routing key
+}
+
+// NewShardedStore creates a store with opts.Shards shards. Sharded stores are
+// kept in memory only, so opts.Path must be empty.
+func NewShardedStore(opts StoreOptions) (*ShardedStore, error) {
+	if opts.Path != "" {
+		return nil, errors.New("a sharded store can't be persisted to a data file")
+	}
+
+	s := &ShardedStore{shards: make([]*ReceiptStore, opts.Shards), dedup: opts.Deduplicate}
+	newID := opts.idGenerator()
+	for i := range s.shards {
+		shardOpts := opts
+		shardOpts.Capacity = opts.Capacity / opts.Shards
+		shard, err := NewReceiptStore(shardOpts)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		// Each shard only hands out IDs that hash back to it, so lookups by
+		// ID find the receipt without consulting every shard. A generator
+		// that keeps missing the shard, such as a constant one in a test,
+		// fails the add instead of spinning forever.
+		shard.newID = func() (string, error) {
+			for range idAttemptsPerShard * len(s.shards) {
+				if id := newID(); s.shardIndex(id) == i {
+					return id, nil
+				}
+			}
+			return "", errShardID
+		}
+		s.shards[i] = shard
+	}
+	return s, nil
+}
+
+// idAttemptsPerShard, times the number of shards, bounds how many IDs are
+// drawn for a receipt before giving up. Random IDs miss a shard that many
+// times in a row with a probability below e^-100.
+const idAttemptsPerShard = 100
+
+// errShardID is returned by AddReceipt when the ID generator didn't produce
+// an ID for the receipt's shard within the attempts allowed.
+var errShardID = errors.New("no generated receipt ID belongs to the receipt's shard")
+
+// shardIndex returns the index of the shard key is routed to.
+func (s *ShardedStore) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// shard returns the shard holding the receipt with the given ID.
+func (s *ShardedStore) shard(id string) *ReceiptStore {
+	return s.shards[s.shardIndex(id)]
+}
+
+// AddReceipt scores and stores a receipt in the shard its idempotency key, or
+// content, is routed to, unless a shard already holds a receipt with the same
+// key or content. Receipts with neither are spread evenly.
+func (s *ShardedStore) AddReceipt(ctx context.Context, receipt scoring.Receipt, idempotencyKey string) (string, int, error) {
+	var hash string
+	if s.dedup {
+		hash = contentHash(receipt)
+	}
+
+	// The key is looked for on every shard before the content, so a replay
+	// returns its own receipt rather than a duplicate on an earlier shard.
+	if idempotencyKey != "" {
+		for _, shard := range s.shards {
+			if id, points, found, err := shard.findExisting("", idempotencyKey); err != nil || found {
+				return id, points, err
+			}
+		}
+	}
+	if s.dedup {
+		for _, shard := range s.shards {
+			if id, points, found, err := shard.findExisting(hash, idempotencyKey); err != nil || found {
+				return id, points, err
+			}
+		}
+	}
+
+	var shard *ReceiptStore
+	switch {
+	case idempotencyKey != "":
+		shard = s.shards[s.shardIndex(idempotencyKey)]
+	case s.dedup:
+		shard = s.shards[s.shardIndex(hash)]
+	default:
+		shard = s.shards[s.next.Add(1)%uint64(len(s.shards))]
+	}
+	return shard.AddReceipt(ctx, receipt, idempotencyKey)
+}
+
+// Score calculates a receipt's points with the store's rules, applying the
+// points cap. Nothing is stored.
+func (s *ShardedStore) Score(ctx context.Context, receipt scoring.Receipt) scoring.PointsBreakdown {
+	return s.shards[0].Score(ctx, receipt)
+}
+
+// Rules returns the rules receipts are currently scored with.
+func (s *ShardedStore) Rules() scoring.RulesConfig {
+	return s.shards[0].Rules()
+}
+
+// SetRules replaces the rules of every shard. A receipt scored while the
+// shards are being updated may still get the old rules.
+func (s *ShardedStore) SetRules(rules scoring.RulesConfig) {
+	for _, shard := range s.shards {
+		shard.SetRules(rules)
+	}
+}
+
+func (s *ShardedStore) Rescore(ctx context.Context, id string) (int, bool, error) {
+	return s.shard(id).Rescore(ctx, id)
+}
+
+func (s *ShardedStore) GetPoints(id string) (int, bool, error) {
+	return s.shard(id).GetPoints(id)
+}
+
+// LookupKey returns the ID of the receipt stored under an idempotency key.
+// Keys aren't always routed by themselves, so every shard is asked.
+func (s *ShardedStore) LookupKey(idempotencyKey string) (string, bool, error) {
+	for _, shard := range s.shards {
+		if id, exists, err := shard.LookupKey(idempotencyKey); exists || err != nil {
+			return id, exists, err
+		}
+	}
+	return "", false, nil
+}
+
+func (s *ShardedStore) GetBreakdown(id string) (scoring.PointsBreakdown, bool, error) {
+	return s.shard(id).GetBreakdown(id)
+}
+
+func (s *ShardedStore) GetReceipt(id string) (scoring.Receipt, bool, error) {
+	return s.shard(id).GetReceipt(id)
+}
+
+func (s *ShardedStore) Exists(id string) (bool, error) {
+	return s.shard(id).Exists(id)
+}
+
+// GetScore returns a receipt's points along with when they were computed.
+func (s *ShardedStore) GetScore(id string) (ScoreInfo, bool, error) {
+	return s.shard(id).GetScore(id)
+}
+
+// Expired reports whether a receipt with the given ID expired recently.
+func (s *ShardedStore) Expired(id string) (bool, error) {
+	return s.shard(id).Expired(id)
+}
+
+func (s *ShardedStore) DeleteReceipt(id string) (bool, error) {
+	return s.shard(id).DeleteReceipt(id)
+}
+
+// snapshot returns the receipts of every shard, merged into insertion order.
+func (s *ShardedStore) snapshot() []receiptEntry {
+	var entries []receiptEntry
+	for _, shard := range s.shards {
+		entries = append(entries, shard.snapshot()...)
+	}
+	slices.SortStableFunc(entries, func(a, b receiptEntry) int {
+		return a.stored.CreatedAt.Compare(b.stored.CreatedAt)
+	})
+	return entries
+}
+
+// ListReceipts returns up to limit receipts matching filter in insertion
+// order, skipping the first offset, along with the total number that match.
+func (s *ShardedStore) ListReceipts(limit, offset int, filter ReceiptFilter) ([]ReceiptSummary, int, error) {
+	page, total := filterPage(s.snapshot(), filter, limit, offset)
+	return page, total, nil
+}
+
+// WalkReceipts calls fn for every stored receipt in insertion order, stopping
+// at the first error fn returns.
+func (s *ShardedStore) WalkReceipts(fn func(id string, receipt scoring.Receipt, points int) error) error {
+	for _, entry := range s.snapshot() {
+		if err := fn(entry.id, entry.stored.Receipt, entry.stored.Breakdown.Total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats computes aggregate statistics over the receipts in every shard.
+func (s *ShardedStore) Stats() (ReceiptStats, error) {
+	var stats ReceiptStats
+	for _, shard := range s.shards {
+		shardStats, err := shard.Stats()
+		if err != nil {
+			return ReceiptStats{}, err
+		}
+		if shardStats.Count == 0 {
+			continue
+		}
+		if stats.Count == 0 || shardStats.MinPoints < stats.MinPoints {
+			stats.MinPoints = shardStats.MinPoints
+		}
+		if stats.Count == 0 || shardStats.MaxPoints > stats.MaxPoints {
+			stats.MaxPoints = shardStats.MaxPoints
+		}
+		stats.Count += shardStats.Count
+		stats.TotalPoints += shardStats.TotalPoints
+	}
+	if stats.Count > 0 {
+		stats.AveragePoints = float64(stats.TotalPoints) / float64(stats.Count)
+	}
+	return stats, nil
+}
+
+// SummaryByRetailer totals the points and receipts stored for each retailer
+// across every shard.
+func (s *ShardedStore) SummaryByRetailer() (map[string]RetailerSummary, error) {
+	summary := make(map[string]RetailerSummary)
+	for _, shard := range s.shards {
+		shardSummary, err := shard.SummaryByRetailer()
+		if err != nil {
+			return nil, err
+		}
+		for retailer, r := range shardSummary {
+			merged := summary[retailer]
+			merged.Points += r.Points
+			merged.Count += r.Count
+			summary[retailer] = merged
+		}
+	}
+	return summary, nil
+}
+
+// Clear removes every stored receipt and returns how many were removed.
+func (s *ShardedStore) Clear() (int, error) {
+	removed := 0
+	for _, shard := range s.shards {
+		n, err := shard.Clear()
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Flush returns ErrNotPersisted; sharded stores are never persisted.
+func (s *ShardedStore) Flush() (int, error) {
+	return 0, ErrNotPersisted
+}
+
+// Close stops each shard's background work.
+func (s *ShardedStore) Close() error {
+	for _, shard := range s.shards {
+		if shard != nil {
+			shard.Close()
+		}
+	}
+	return nil
+}
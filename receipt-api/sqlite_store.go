@@ -0,0 +1,747 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"receipt-api/scoring"
+)
+
+// defaultSQLitePath is the database file used when StoreOptions.Path is
+// empty.
+const defaultSQLitePath = "receipts.db"
+
+// sqliteTimeLayout stores timestamps in UTC with a fixed width so they sort
+// correctly as text.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS receipts (
+	id              TEXT PRIMARY KEY,
+	receipt         TEXT NOT NULL,
+	points          INTEGER NOT NULL,
+	breakdown       TEXT NOT NULL,
+	idempotency_key TEXT UNIQUE,
+	content_hash    TEXT NOT NULL,
+	created_at      TEXT NOT NULL,
+	pending         INTEGER NOT NULL DEFAULT 0,
+	computed_at     TEXT,
+	rescored        INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS receipts_content_hash ON receipts (content_hash);
+CREATE INDEX IF NOT EXISTS receipts_created_at ON receipts (created_at);
+CREATE TABLE IF NOT EXISTS receipt_alias_keys (
+	idempotency_key TEXT PRIMARY KEY,
+	receipt_id      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS receipt_alias_keys_receipt_id ON receipt_alias_keys (receipt_id);
+CREATE TABLE IF NOT EXISTS expired_receipts (
+	id         TEXT PRIMARY KEY,
+	expired_at TEXT NOT NULL
+);
+`
+
+// sqliteMigrations bring databases created by earlier versions up to
+// sqliteSchema. Each is run once at startup; "duplicate column" errors mean
+// it has already been applied.
+var sqliteMigrations = []string{
+	`ALTER TABLE receipts ADD COLUMN pending INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE receipts ADD COLUMN computed_at TEXT`,
+	`ALTER TABLE receipts ADD COLUMN rescored INTEGER NOT NULL DEFAULT 0`,
+}
+
+// SQLiteStore is a Store that keeps receipts in a SQLite database. Every
+// write is committed immediately, so Flush has nothing to do.
+type SQLiteStore struct {
+	db        *sql.DB
+	maxPoints int
+	dedup     bool
+	rules     atomic.Pointer[scoring.RulesConfig]
+	ttl       time.Duration
+	onAdd     func(id string, receipt scoring.Receipt, points int)
+	async     bool
+	jobs      chan string // IDs of pending receipts awaiting the worker
+	done      chan struct{}
+	newID     func() string
+}
+
+// NewSQLiteStore opens the database at opts.Path, creating it and its schema
+// if needed.
+func NewSQLiteStore(opts StoreOptions) (*SQLiteStore, error) {
+	path := opts.Path
+	if path == "" {
+		path = defaultSQLitePath
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection serializes access instead
+	// of failing with SQLITE_BUSY under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, migration := range sqliteMigrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	s := &SQLiteStore{
+		db:        db,
+		maxPoints: opts.MaxPoints,
+		dedup:     opts.Deduplicate,
+		ttl:       opts.TTL,
+		onAdd:     opts.OnAdd,
+		async:     opts.AsyncScoring,
+		newID:     opts.idGenerator(),
+		done:      make(chan struct{}),
+	}
+	s.SetRules(opts.Rules)
+	if s.async {
+		pending, err := s.pendingIDs()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		s.jobs = make(chan string, scoreQueueSize)
+		go s.work()
+		go s.enqueue(pending...)
+	}
+	return s, nil
+}
+
+// Close stops the scoring worker and closes the database.
+func (s *SQLiteStore) Close() error {
+	close(s.done)
+	return s.db.Close()
+}
+
+// pendingIDs returns the IDs of receipts still waiting to be scored, oldest
+// first.
+func (s *SQLiteStore) pendingIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM receipts WHERE pending = 1 ORDER BY created_at, rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// enqueue hands pending receipts to the worker, giving up if the store is
+// closed first.
+func (s *SQLiteStore) enqueue(ids ...string) {
+	for _, id := range ids {
+		select {
+		case s.jobs <- id:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// work scores pending receipts until the store is closed.
+func (s *SQLiteStore) work() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case id := <-s.jobs:
+			if err := s.scorePending(id); err != nil {
+				slog.Error("failed to score pending receipt", "receiptId", id, "error", err)
+			}
+		}
+	}
+}
+
+// scorePending scores a pending receipt and stores its points. A receipt
+// deleted or rescored in the meantime is left alone.
+func (s *SQLiteStore) scorePending(id string) error {
+	var data string
+	err := s.db.QueryRow(`SELECT receipt FROM receipts WHERE id = ? AND pending = 1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var receipt scoring.Receipt
+	if err := json.Unmarshal([]byte(data), &receipt); err != nil {
+		return err
+	}
+	breakdown := s.Score(context.Background(), receipt)
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`UPDATE receipts SET points = ?, breakdown = ?, pending = 0, computed_at = ? WHERE id = ? AND pending = 1`,
+		breakdown.Total, string(breakdownJSON), time.Now().UTC().Format(sqliteTimeLayout), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	receiptsProcessed.Inc()
+	pointsAwarded.Observe(float64(breakdown.Total))
+	if s.onAdd != nil {
+		s.onAdd(id, receipt, breakdown.Total)
+	}
+	return nil
+}
+
+// Flush writes nothing, since writes are committed as they happen, and returns
+// how many receipts the database holds.
+func (s *SQLiteStore) Flush() (int, error) {
+	if err := s.expire(s.db); err != nil {
+		return 0, err
+	}
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM receipts`).Scan(&count)
+	return count, err
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// expire deletes receipts older than the TTL, recording their IDs in
+// expired_receipts for tombstoneTTL. The tombstones are written first, so an
+// expiring receipt is always found in one table or the other.
+func (s *SQLiteStore) expire(db execer) error {
+	if s.ttl <= 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	cutoff := now.Add(-s.ttl).Format(sqliteTimeLayout)
+	if _, err := db.Exec(`INSERT OR REPLACE INTO expired_receipts (id, expired_at) SELECT id, ? FROM receipts WHERE created_at <= ?`, now.Format(sqliteTimeLayout), cutoff); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM receipt_alias_keys WHERE receipt_id IN (SELECT id FROM receipts WHERE created_at <= ?)`, cutoff); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM receipts WHERE created_at <= ?`, cutoff); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM expired_receipts WHERE expired_at <= ?`, now.Add(-tombstoneTTL).Format(sqliteTimeLayout))
+	return err
+}
+
+// byKeyQuery selects the ID, points and pending flag of the receipt stored
+// under an idempotency key, given twice, whether it was stored with the key or
+// a submission with the key was deduplicated onto it.
+const byKeyQuery = `SELECT id, points, pending FROM receipts WHERE idempotency_key = ? OR id = (SELECT receipt_id FROM receipt_alias_keys WHERE idempotency_key = ?)`
+
+// AddReceipt scores and stores a receipt, returning its new ID and points.
+// Idempotency keys, deduplication and asynchronous scoring behave as for
+// ReceiptStore; a deduplicated submission's key is recorded in
+// receipt_alias_keys.
+func (s *SQLiteStore) AddReceipt(ctx context.Context, receipt scoring.Receipt, idempotencyKey string) (string, int, error) {
+	var breakdown scoring.PointsBreakdown
+	if !s.async {
+		breakdown = s.Score(ctx, receipt)
+	}
+	hash := contentHash(receipt)
+
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return "", 0, err
+	}
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+
+	if err := s.expire(tx); err != nil {
+		return "", 0, err
+	}
+
+	var id string
+	var points int
+	var pending bool
+	if idempotencyKey != "" {
+		err := tx.QueryRow(byKeyQuery, idempotencyKey, idempotencyKey).Scan(&id, &points, &pending)
+		if err == nil {
+			return matchedReceipt(id, points, pending)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", 0, err
+		}
+	}
+
+	if s.dedup {
+		err := tx.QueryRow(`SELECT id, points, pending FROM receipts WHERE content_hash = ? ORDER BY created_at LIMIT 1`, hash).Scan(&id, &points, &pending)
+		if err == nil {
+			if idempotencyKey == "" {
+				return matchedReceipt(id, points, pending)
+			}
+			// The key may be left over from a receipt since deleted or expired.
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO receipt_alias_keys (idempotency_key, receipt_id) VALUES (?, ?)`, idempotencyKey, id); err != nil {
+				return "", 0, err
+			}
+			if err := tx.Commit(); err != nil {
+				return "", 0, err
+			}
+			return matchedReceipt(id, points, pending)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", 0, err
+		}
+	}
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+
+	createdAt := time.Now().UTC().Format(sqliteTimeLayout)
+	var computedAt sql.NullString
+	if !s.async {
+		computedAt = sql.NullString{String: createdAt, Valid: true}
+	}
+
+	id = s.newID()
+	_, err = tx.Exec(
+		`INSERT INTO receipts (id, receipt, points, breakdown, idempotency_key, content_hash, created_at, pending, computed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, string(receiptJSON), breakdown.Total, string(breakdownJSON), key, hash, createdAt, s.async, computedAt,
+	)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", 0, err
+	}
+
+	if s.async {
+		s.enqueue(id)
+		return id, 0, nil
+	}
+
+	receiptsProcessed.Inc()
+	pointsAwarded.Observe(float64(breakdown.Total))
+	if s.onAdd != nil {
+		s.onAdd(id, receipt, breakdown.Total)
+	}
+
+	return id, breakdown.Total, nil
+}
+
+// Score calculates a receipt's points with the store's rules, applying the
+// points cap. Nothing is stored.
+func (s *SQLiteStore) Score(ctx context.Context, receipt scoring.Receipt) scoring.PointsBreakdown {
+	return score(ctx, receipt, s.Rules(), s.maxPoints)
+}
+
+// Rules returns the rules receipts are currently scored with.
+func (s *SQLiteStore) Rules() scoring.RulesConfig {
+	return *s.rules.Load()
+}
+
+// SetRules replaces the rules receipts are scored with from now on. Receipts
+// already stored keep their points until they are rescored.
+func (s *SQLiteStore) SetRules(rules scoring.RulesConfig) {
+	s.rules.Store(&rules)
+}
+
+// matchedReceipt returns what AddReceipt reports for a stored receipt matched
+// by idempotency key or content, with ErrPending if it hasn't been scored yet.
+func matchedReceipt(id string, points int, pending bool) (string, int, error) {
+	if pending {
+		return id, 0, ErrPending
+	}
+	return id, points, nil
+}
+
+// Rescore recalculates the points of a stored receipt with the current rules
+// and returns the new total. It reports false if the receipt doesn't exist.
+func (s *SQLiteStore) Rescore(ctx context.Context, id string) (int, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	if err := s.expire(tx); err != nil {
+		return 0, false, err
+	}
+
+	var receiptJSON string
+	var pending bool
+	err = tx.QueryRow(`SELECT receipt, pending FROM receipts WHERE id = ?`, id).Scan(&receiptJSON, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	var receipt scoring.Receipt
+	if err := json.Unmarshal([]byte(receiptJSON), &receipt); err != nil {
+		return 0, true, err
+	}
+	breakdown := s.Score(ctx, receipt)
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return 0, true, err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE receipts SET points = ?, breakdown = ?, pending = 0, computed_at = ?, rescored = 1 WHERE id = ?`,
+		breakdown.Total, string(breakdownJSON), time.Now().UTC().Format(sqliteTimeLayout), id,
+	); err != nil {
+		return 0, true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, true, err
+	}
+
+	// As in ReceiptStore, completing a pending receipt announces it.
+	if pending {
+		receiptsProcessed.Inc()
+		pointsAwarded.Observe(float64(breakdown.Total))
+		if s.onAdd != nil {
+			s.onAdd(id, receipt, breakdown.Total)
+		}
+	}
+	return breakdown.Total, true, nil
+}
+
+// column reads a single column of the receipt with the given ID, reporting
+// false if it doesn't exist.
+func (s *SQLiteStore) column(column, id string, dest any) (bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return false, err
+	}
+
+	err := s.db.QueryRow(`SELECT `+column+` FROM receipts WHERE id = ?`, id).Scan(dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// scoredColumn is like column for a column that only holds a value once the
+// receipt has been scored, returning ErrPending until it has.
+func (s *SQLiteStore) scoredColumn(column, id string, dest any) (bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return false, err
+	}
+
+	var pending bool
+	err := s.db.QueryRow(`SELECT `+column+`, pending FROM receipts WHERE id = ?`, id).Scan(dest, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err == nil && pending {
+		return true, ErrPending
+	}
+	return err == nil, err
+}
+
+func (s *SQLiteStore) GetPoints(id string) (int, bool, error) {
+	var points int
+	exists, err := s.scoredColumn("points", id, &points)
+	return points, exists, err
+}
+
+// GetScore returns a receipt's points along with when they were computed.
+// Receipts stored before computation times were kept report their creation
+// time.
+func (s *SQLiteStore) GetScore(id string) (ScoreInfo, bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return ScoreInfo{}, false, err
+	}
+
+	var info ScoreInfo
+	var computedAt string
+	var pending bool
+	err := s.db.QueryRow(`SELECT points, COALESCE(computed_at, created_at), rescored, pending FROM receipts WHERE id = ?`, id).
+		Scan(&info.Points, &computedAt, &info.Rescored, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScoreInfo{}, false, nil
+	}
+	if err != nil {
+		return ScoreInfo{}, false, err
+	}
+	if pending {
+		return ScoreInfo{}, true, ErrPending
+	}
+	info.ComputedAt, err = time.Parse(sqliteTimeLayout, computedAt)
+	return info, true, err
+}
+
+// LookupKey returns the ID of the receipt stored under an idempotency key,
+// reporting false if there is none.
+func (s *SQLiteStore) LookupKey(idempotencyKey string) (string, bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return "", false, err
+	}
+
+	var id string
+	var points int
+	var pending bool
+	err := s.db.QueryRow(byKeyQuery, idempotencyKey, idempotencyKey).Scan(&id, &points, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return id, err == nil, err
+}
+
+func (s *SQLiteStore) GetBreakdown(id string) (scoring.PointsBreakdown, bool, error) {
+	var data string
+	exists, err := s.scoredColumn("breakdown", id, &data)
+	if !exists || err != nil {
+		return scoring.PointsBreakdown{}, exists, err
+	}
+
+	var breakdown scoring.PointsBreakdown
+	err = json.Unmarshal([]byte(data), &breakdown)
+	return breakdown, true, err
+}
+
+func (s *SQLiteStore) GetReceipt(id string) (scoring.Receipt, bool, error) {
+	var data string
+	exists, err := s.column("receipt", id, &data)
+	if !exists || err != nil {
+		return scoring.Receipt{}, exists, err
+	}
+
+	var receipt scoring.Receipt
+	err = json.Unmarshal([]byte(data), &receipt)
+	return receipt, true, err
+}
+
+// Exists reports whether a receipt with the given ID is stored, whether or
+// not it has been scored yet.
+func (s *SQLiteStore) Exists(id string) (bool, error) {
+	var pending bool
+	return s.column("pending", id, &pending)
+}
+
+// Expired reports whether a receipt with the given ID expired within the
+// last tombstoneTTL.
+func (s *SQLiteStore) Expired(id string) (bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return false, err
+	}
+
+	var expired bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM expired_receipts WHERE id = ?)`, id).Scan(&expired)
+	return expired, err
+}
+
+// DeleteReceipt removes the receipt with the given ID and reports whether it
+// existed.
+func (s *SQLiteStore) DeleteReceipt(id string) (bool, error) {
+	if err := s.expire(s.db); err != nil {
+		return false, err
+	}
+
+	result, err := s.db.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM receipt_alias_keys WHERE receipt_id = ?`, id); err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListReceipts returns up to limit receipts matching filter in insertion
+// order, skipping the first offset, along with the total number that match.
+func (s *SQLiteStore) ListReceipts(limit, offset int, filter ReceiptFilter) ([]ReceiptSummary, int, error) {
+	if err := s.expire(s.db); err != nil {
+		return nil, 0, err
+	}
+
+	// Tags and purchase dates live in the receipt JSON. Dates are stored as
+	// YYYY-MM-DD, so they compare correctly as text.
+	var conditions []string
+	var args []any
+	for key, value := range filter.Tags {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(receipt, '$.tags') WHERE key = ? AND value = ?)`)
+		args = append(args, key, value)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, `json_extract(receipt, '$.purchaseDate') >= ?`)
+		args = append(args, filter.From.Format(time.DateOnly))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, `json_extract(receipt, '$.purchaseDate') <= ?`)
+		args = append(args, filter.To.Format(time.DateOnly))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM receipts`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`SELECT id, points, pending FROM receipts`+where+` ORDER BY created_at, rowid LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	page := make([]ReceiptSummary, 0, min(limit, total))
+	for rows.Next() {
+		var summary ReceiptSummary
+		if err := rows.Scan(&summary.ID, &summary.Points, &summary.Pending); err != nil {
+			return nil, 0, err
+		}
+		page = append(page, summary)
+	}
+	return page, total, rows.Err()
+}
+
+// walkBatchSize is how many rows WalkReceipts reads per query. Reading in
+// batches keeps the single connection free between batches while fn runs.
+const walkBatchSize = 500
+
+// WalkReceipts calls fn for every stored receipt in insertion order, stopping
+// at the first error fn returns.
+func (s *SQLiteStore) WalkReceipts(fn func(id string, receipt scoring.Receipt, points int) error) error {
+	if err := s.expire(s.db); err != nil {
+		return err
+	}
+
+	type row struct {
+		id      string
+		receipt scoring.Receipt
+		points  int
+	}
+
+	var after int64
+	for {
+		rows, err := s.db.Query(`SELECT rowid, id, receipt, points FROM receipts WHERE rowid > ? ORDER BY rowid LIMIT ?`, after, walkBatchSize)
+		if err != nil {
+			return err
+		}
+
+		batch := make([]row, 0, walkBatchSize)
+		for rows.Next() {
+			var r row
+			var data string
+			if err := rows.Scan(&after, &r.id, &data, &r.points); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := json.Unmarshal([]byte(data), &r.receipt); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range batch {
+			if err := fn(r.id, r.receipt, r.points); err != nil {
+				return err
+			}
+		}
+		if len(batch) < walkBatchSize {
+			return nil
+		}
+	}
+}
+
+// Stats computes aggregate statistics over the stored receipts.
+func (s *SQLiteStore) Stats() (ReceiptStats, error) {
+	if err := s.expire(s.db); err != nil {
+		return ReceiptStats{}, err
+	}
+
+	var stats ReceiptStats
+	err := s.db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(points), 0), COALESCE(AVG(points), 0), COALESCE(MIN(points), 0), COALESCE(MAX(points), 0) FROM receipts WHERE pending = 0`,
+	).Scan(&stats.Count, &stats.TotalPoints, &stats.AveragePoints, &stats.MinPoints, &stats.MaxPoints)
+	return stats, err
+}
+
+// SummaryByRetailer totals the points and receipts stored for each retailer
+// name, keyed by its retailerKey. SQLite can't collapse whitespace, so names
+// that differ only in whitespace are merged after grouping.
+func (s *SQLiteStore) SummaryByRetailer() (map[string]RetailerSummary, error) {
+	if err := s.expire(s.db); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT json_extract(receipt, '$.retailer'), SUM(points), COUNT(*) FROM receipts WHERE pending = 0 GROUP BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := make(map[string]RetailerSummary)
+	for rows.Next() {
+		var retailer string
+		var r RetailerSummary
+		if err := rows.Scan(&retailer, &r.Points, &r.Count); err != nil {
+			return nil, err
+		}
+		key := retailerKey(retailer)
+		merged := summary[key]
+		merged.Points += r.Points
+		merged.Count += r.Count
+		summary[key] = merged
+	}
+	return summary, rows.Err()
+}
+
+// Clear removes every stored receipt and returns how many were removed.
+func (s *SQLiteStore) Clear() (int, error) {
+	result, err := s.db.Exec(`DELETE FROM receipts`)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM receipt_alias_keys`); err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM expired_receipts`); err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,1026 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+
+	"receipt-api/scoring"
+)
+
+// storedReceipt is a processed receipt together with the points it earned.
+type storedReceipt struct {
+	Receipt        scoring.Receipt         `json:"receipt"`
+	Breakdown      scoring.PointsBreakdown `json:"breakdown"`
+	IdempotencyKey string                  `json:"idempotencyKey,omitempty"`
+	CreatedAt      time.Time               `json:"createdAt"`
+
+	// AliasKeys are the idempotency keys of later submissions that were
+	// deduplicated onto this receipt, so retries and lookups with them find
+	// it too.
+	AliasKeys []string `json:"aliasKeys,omitempty"`
+
+	// Pending is set while a receipt stored with asynchronous scoring waits
+	// for its points.
+	Pending bool `json:"pending,omitempty"`
+
+	// ComputedAt is when the points were last calculated, and Rescored is
+	// set once that was by Rescore rather than on submission. Receipts
+	// stored by earlier versions have no ComputedAt.
+	ComputedAt time.Time `json:"computedAt"`
+	Rescored   bool      `json:"rescored,omitempty"`
+}
+
+// ScoreInfo is a stored receipt's points along with when they were computed.
+type ScoreInfo struct {
+	Points     int       `json:"points"`
+	ComputedAt time.Time `json:"computedAt"`
+	Rescored   bool      `json:"rescored"`
+}
+
+// ErrNotPersisted is returned by Flush for a store kept in memory only, which
+// has no file to write.
+var ErrNotPersisted = errors.New("receipt store is not persisted")
+
+// ErrPending is returned when looking up the points of a receipt stored with
+// asynchronous scoring that hasn't been scored yet.
+var ErrPending = errors.New("receipt is still being scored")
+
+// scoreQueueSize is how many receipts may wait for asynchronous scoring before
+// AddReceipt blocks.
+const scoreQueueSize = 1000
+
+// Store persists processed receipts and the points they earned.
+type Store interface {
+	AddReceipt(ctx context.Context, receipt scoring.Receipt, idempotencyKey string) (string, int, error)
+	Score(ctx context.Context, receipt scoring.Receipt) scoring.PointsBreakdown
+	Rules() scoring.RulesConfig
+	SetRules(rules scoring.RulesConfig)
+	Rescore(ctx context.Context, id string) (int, bool, error)
+	GetPoints(id string) (int, bool, error)
+	GetScore(id string) (ScoreInfo, bool, error)
+	LookupKey(idempotencyKey string) (string, bool, error)
+	GetBreakdown(id string) (scoring.PointsBreakdown, bool, error)
+	GetReceipt(id string) (scoring.Receipt, bool, error)
+	Exists(id string) (bool, error)
+	Expired(id string) (bool, error)
+	DeleteReceipt(id string) (bool, error)
+	ListReceipts(limit, offset int, filter ReceiptFilter) ([]ReceiptSummary, int, error)
+	WalkReceipts(fn func(id string, receipt scoring.Receipt, points int) error) error
+	Stats() (ReceiptStats, error)
+	SummaryByRetailer() (map[string]RetailerSummary, error)
+	Clear() (int, error)
+	Flush() (int, error)
+	Close() error
+}
+
+// Store backends selectable with OpenStore.
+const (
+	StoreMemory = "memory"
+	StoreSQLite = "sqlite"
+)
+
+// OpenStore opens the named store backend. An empty name selects
+// StoreMemory, which is sharded when opts.Shards is more than one.
+func OpenStore(backend string, opts StoreOptions) (Store, error) {
+	switch backend {
+	case "", StoreMemory:
+		if opts.Shards > 1 {
+			return NewShardedStore(opts)
+		}
+		return NewReceiptStore(opts)
+	case StoreSQLite:
+		return NewSQLiteStore(opts)
+	default:
+		return nil, errors.New("unknown store backend " + strconv.Quote(backend))
+	}
+}
+
+// ReceiptStore is a Store that keeps receipts in memory, optionally
+// persisting them to a JSON file.
+type ReceiptStore struct {
+	mu        sync.Mutex
+	receipts  map[string]storedReceipt
+	order     []string          // receipt IDs in insertion order
+	keys      map[string]string // idempotency key -> receipt ID
+	hashes    map[string]string // content hash -> receipt ID
+	path      string
+	maxPoints int
+	dedup     bool
+	rules     atomic.Pointer[scoring.RulesConfig]
+	ttl       time.Duration
+	expired   map[string]time.Time // recently expired receipt ID -> when it expired
+	graves    []string             // IDs in expired, oldest first
+	done      chan struct{}
+	unsaved   bool // receipts were expired since the last save
+	onAdd     func(id string, receipt scoring.Receipt, points int)
+	async     bool
+	jobs      chan string // IDs of pending receipts awaiting the worker
+	newID     func() (string, error)
+}
+
+// StoreOptions configures a ReceiptStore.
+type StoreOptions struct {
+	// Path is the JSON file receipts are persisted to. Existing receipts are
+	// loaded from it, and every write is flushed back. An empty path keeps
+	// the store in memory only. For a SQLiteStore it is the database file.
+	Path string
+
+	// MaxPoints caps the points awarded to a single receipt. Zero means no
+	// cap.
+	MaxPoints int
+
+	// Deduplicate makes AddReceipt return the existing ID when a receipt
+	// with identical content has already been stored.
+	Deduplicate bool
+
+	// Rules are the point values receipts are scored with.
+	Rules scoring.RulesConfig
+
+	// TTL is how long a receipt is kept after it was stored. Zero keeps
+	// receipts forever.
+	TTL time.Duration
+
+	// OnAdd, if set, is called after a new receipt has been stored. It is
+	// not called for idempotent replays or deduplicated submissions, and it
+	// must not block. With AsyncScoring it is called once the receipt has
+	// been scored.
+	OnAdd func(id string, receipt scoring.Receipt, points int)
+
+	// AsyncScoring makes AddReceipt store receipts without scoring them and
+	// return at once, with zero points. A background worker scores them in
+	// order; until it does, GetPoints and GetBreakdown return ErrPending and
+	// Stats and SummaryByRetailer leave them out.
+	AsyncScoring bool
+
+	// NewID generates the IDs of new receipts. It defaults to uuid.NewString;
+	// tests can supply a deterministic sequence. A ShardedStore skips IDs
+	// that don't belong to the receipt's shard, so a generator repeating the
+	// same ID makes its AddReceipt fail.
+	NewID func() string
+
+	// Capacity is how many receipts a memory store preallocates room for,
+	// avoiding map growth while it fills. Zero starts empty.
+	Capacity int
+
+	// Shards is how many independently locked shards a memory store is
+	// split into. Zero or one keeps a single ReceiptStore; more selects a
+	// ShardedStore, which can't be combined with Path.
+	Shards int
+}
+
+// idGenerator returns opts.NewID, or uuid.NewString if it is unset.
+func (opts StoreOptions) idGenerator() func() string {
+	if opts.NewID != nil {
+		return opts.NewID
+	}
+	return uuid.NewString
+}
+
+func NewReceiptStore(opts StoreOptions) (*ReceiptStore, error) {
+	generate := opts.idGenerator()
+	s := &ReceiptStore{
+		receipts:  make(map[string]storedReceipt, opts.Capacity),
+		keys:      make(map[string]string),
+		hashes:    make(map[string]string, opts.Capacity),
+		path:      opts.Path,
+		maxPoints: opts.MaxPoints,
+		dedup:     opts.Deduplicate,
+		ttl:       opts.TTL,
+		expired:   make(map[string]time.Time),
+		onAdd:     opts.OnAdd,
+		async:     opts.AsyncScoring,
+		newID:     func() (string, error) { return generate(), nil },
+		done:      make(chan struct{}),
+	}
+	s.SetRules(opts.Rules)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if s.ttl > 0 {
+		go s.sweep(min(s.ttl, time.Minute))
+	}
+	if s.async {
+		s.jobs = make(chan string, scoreQueueSize)
+		go s.work()
+
+		// Receipts still pending when the store was last closed are queued
+		// again in the background, since there may be more than fit.
+		var pending []string
+		for _, id := range s.order {
+			if s.receipts[id].Pending {
+				pending = append(pending, id)
+			}
+		}
+		go s.enqueue(pending...)
+	}
+	return s, nil
+}
+
+// enqueue hands pending receipts to the worker, giving up if the store is
+// closed first.
+func (s *ReceiptStore) enqueue(ids ...string) {
+	for _, id := range ids {
+		select {
+		case s.jobs <- id:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// work scores pending receipts until the store is closed.
+func (s *ReceiptStore) work() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case id := <-s.jobs:
+			s.scorePending(id)
+		}
+	}
+}
+
+// scorePending scores a pending receipt and stores its points. The lock is
+// released while scoring so slow rules don't hold up requests; a receipt
+// deleted or rescored in the meantime is left alone.
+func (s *ReceiptStore) scorePending(id string) {
+	s.mu.Lock()
+	stored, exists := s.receipts[id]
+	s.mu.Unlock()
+	if !exists || !stored.Pending {
+		return
+	}
+
+	breakdown := s.Score(context.Background(), stored.Receipt)
+
+	s.mu.Lock()
+	stored, exists = s.receipts[id]
+	if !exists || !stored.Pending {
+		s.mu.Unlock()
+		return
+	}
+	stored.Breakdown = breakdown
+	stored.Pending = false
+	stored.ComputedAt = time.Now()
+	s.receipts[id] = stored
+	if err := s.save(); err != nil {
+		// The points stay in memory and are persisted by the next save.
+		slog.Error("failed to persist scored receipt", "receiptId", id, "error", err)
+	}
+	s.mu.Unlock()
+
+	receiptsProcessed.Inc()
+	pointsAwarded.Observe(float64(breakdown.Total))
+	if s.onAdd != nil {
+		s.onAdd(id, stored.Receipt, breakdown.Total)
+	}
+}
+
+// Close stops the background expiry sweep.
+func (s *ReceiptStore) Close() error {
+	close(s.done)
+	return nil
+}
+
+// sweep periodically evicts expired receipts and persists the result, so
+// memory and the data file shrink even when the store is idle.
+func (s *ReceiptStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.expire()
+			if s.unsaved {
+				if err := s.save(); err != nil {
+					slog.Error("failed to persist expired receipts", "error", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// expire evicts receipts older than the TTL, remembering their IDs for
+// tombstoneTTL so Expired can tell them from IDs that never existed.
+// Receipts are ordered by creation time, so only the front of s.order needs
+// to be examined. Evictions are persisted by the next save; tombstones are
+// kept in memory only. Callers must hold s.mu.
+func (s *ReceiptStore) expire() {
+	if s.ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-s.ttl)
+	for len(s.order) > 0 {
+		id := s.order[0]
+		stored := s.receipts[id]
+		if stored.CreatedAt.After(cutoff) {
+			break
+		}
+		// Popping the head keeps eviction constant time; unindex would
+		// search s.order for it.
+		s.order = s.order[1:]
+		s.unindexLookups(id, stored)
+		s.unsaved = s.path != ""
+		s.expired[id] = now
+		s.graves = append(s.graves, id)
+	}
+
+	for len(s.graves) > 0 && now.Sub(s.expired[s.graves[0]]) > tombstoneTTL {
+		delete(s.expired, s.graves[0])
+		s.graves = s.graves[1:]
+	}
+}
+
+// tombstoneTTL is how long the IDs of expired receipts are remembered.
+const tombstoneTTL = 24 * time.Hour
+
+// Expired reports whether a receipt with the given ID expired within the
+// last tombstoneTTL.
+func (s *ReceiptStore) Expired(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	_, expired := s.expired[id]
+	return expired, nil
+}
+
+// AddReceipt scores and stores a receipt, returning its new ID and points.
+// When idempotencyKey is non-empty and a receipt was already stored under the
+// same key, the original ID and points are returned instead and nothing new
+// is stored. The same happens for receipts whose content matches an already
+// stored receipt when deduplication is enabled.
+// With asynchronous scoring the receipt is stored pending and queued for the
+// worker, and zero points are returned. A replay or duplicate of a receipt
+// that is still pending returns its ID with ErrPending.
+func (s *ReceiptStore) AddReceipt(ctx context.Context, receipt scoring.Receipt, idempotencyKey string) (string, int, error) {
+	// Scoring and hashing only read the receipt and a snapshot of the rules,
+	// so do them before taking the lock to keep the critical section short.
+	var breakdown scoring.PointsBreakdown
+	if !s.async {
+		breakdown = s.Score(ctx, receipt)
+	}
+	var hash string
+	if s.dedup {
+		hash = contentHash(receipt)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+
+	id, points, added, err := s.add(receipt, breakdown, hash, idempotencyKey)
+	if err != nil || !added {
+		return id, points, err
+	}
+
+	if s.async {
+		// The queue is handed the ID outside the lock, since the worker needs
+		// it and a full queue blocks until the worker catches up.
+		s.enqueue(id)
+		return id, 0, nil
+	}
+
+	receiptsProcessed.Inc()
+	pointsAwarded.Observe(float64(breakdown.Total))
+	if s.onAdd != nil {
+		s.onAdd(id, receipt, breakdown.Total)
+	}
+
+	return id, breakdown.Total, nil
+}
+
+// add stores a scored, or with asynchronous scoring pending, receipt under a
+// new ID. It reports false along with the existing ID and points when the
+// idempotency key or content hash matches a stored receipt; in the latter
+// case the key is recorded against the existing receipt.
+func (s *ReceiptStore) add(receipt scoring.Receipt, breakdown scoring.PointsBreakdown, hash, idempotencyKey string) (string, int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	if id, points, found, err := s.existing(hash, idempotencyKey); err != nil || found {
+		return id, points, false, err
+	}
+
+	// Generate unique ID
+	id, err := s.newID()
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	// Store receipt and points
+	stored := storedReceipt{Receipt: receipt, Breakdown: breakdown, IdempotencyKey: idempotencyKey, CreatedAt: time.Now(), Pending: s.async}
+	if !s.async {
+		stored.ComputedAt = stored.CreatedAt
+	}
+	s.index(id, stored)
+	if err := s.save(); err != nil {
+		s.unindex(id, stored)
+		return "", 0, false, err
+	}
+
+	return id, breakdown.Total, true, nil
+}
+
+// findExisting is existing for callers that don't hold s.mu. A ShardedStore
+// asks every shard before adding a receipt to one of them.
+func (s *ReceiptStore) findExisting(hash, idempotencyKey string) (string, int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	return s.existing(hash, idempotencyKey)
+}
+
+// existing returns the ID and points of the stored receipt that add would
+// return instead of storing a new one: the one under idempotencyKey, or with
+// deduplication on, the one whose content hash matches, which then has the
+// key recorded against it. An empty hash only checks the key. A receipt that
+// is still pending is returned with ErrPending rather than zero points.
+// Callers must hold s.mu.
+func (s *ReceiptStore) existing(hash, idempotencyKey string) (string, int, bool, error) {
+	if id, exists := s.keys[idempotencyKey]; exists && idempotencyKey != "" {
+		return s.matched(id)
+	}
+
+	if s.dedup && hash != "" {
+		if id, exists := s.hashes[hash]; exists {
+			if err := s.addAliasKey(id, idempotencyKey); err != nil {
+				return "", 0, false, err
+			}
+			return s.matched(id)
+		}
+	}
+	return "", 0, false, nil
+}
+
+// matched returns what existing reports for the stored receipt with the
+// given ID. Callers must hold s.mu.
+func (s *ReceiptStore) matched(id string) (string, int, bool, error) {
+	stored := s.receipts[id]
+	if stored.Pending {
+		return id, 0, true, ErrPending
+	}
+	return id, stored.Breakdown.Total, true, nil
+}
+
+// addAliasKey records idempotencyKey, which no receipt is stored under yet,
+// as another key of the receipt with the given ID. Callers must hold s.mu.
+func (s *ReceiptStore) addAliasKey(id, idempotencyKey string) error {
+	if idempotencyKey == "" {
+		return nil
+	}
+
+	stored := s.receipts[id]
+	updated := stored
+	updated.AliasKeys = append(slices.Clip(stored.AliasKeys), idempotencyKey)
+	s.receipts[id] = updated
+	s.keys[idempotencyKey] = id
+	if err := s.save(); err != nil {
+		s.receipts[id] = stored
+		delete(s.keys, idempotencyKey)
+		return err
+	}
+	return nil
+}
+
+// Score calculates a receipt's points with the store's rules, applying the
+// points cap. Nothing is stored.
+func (s *ReceiptStore) Score(ctx context.Context, receipt scoring.Receipt) scoring.PointsBreakdown {
+	return score(ctx, receipt, s.Rules(), s.maxPoints)
+}
+
+// Rules returns the rules receipts are currently scored with.
+func (s *ReceiptStore) Rules() scoring.RulesConfig {
+	return *s.rules.Load()
+}
+
+// SetRules replaces the rules receipts are scored with from now on. Receipts
+// already stored keep their points until they are rescored.
+func (s *ReceiptStore) SetRules(rules scoring.RulesConfig) {
+	s.rules.Store(&rules)
+}
+
+// score calculates a receipt's points with rules, capped at maxPoints, and
+// records how long the calculation took.
+func score(ctx context.Context, receipt scoring.Receipt, rules scoring.RulesConfig, maxPoints int) scoring.PointsBreakdown {
+	_, span := tracer.Start(ctx, "scoring.Calculate")
+	defer span.End()
+
+	start := time.Now()
+	breakdown := scoring.Calculate(receipt, rules)
+	calculationDuration.Observe(time.Since(start).Seconds())
+
+	breakdown.Cap(maxPoints)
+	span.SetAttributes(attribute.Int("receipt.items", len(receipt.Items)), attribute.Int("receipt.points", breakdown.Total))
+	return breakdown
+}
+
+// Rescore recalculates the points of a stored receipt with the current rules
+// and returns the new total. It reports false if the receipt doesn't exist.
+// As in AddReceipt, the receipt is scored without holding the lock; one
+// deleted or expired in the meantime is reported as not existing.
+func (s *ReceiptStore) Rescore(ctx context.Context, id string) (int, bool, error) {
+	s.mu.Lock()
+	s.expire()
+	stored, exists := s.receipts[id]
+	s.mu.Unlock()
+	if !exists {
+		return 0, false, nil
+	}
+
+	breakdown := s.Score(ctx, stored.Receipt)
+	if err := ctx.Err(); err != nil {
+		return 0, true, err
+	}
+
+	s.mu.Lock()
+	stored, exists = s.receipts[id]
+	if !exists {
+		s.mu.Unlock()
+		return 0, false, nil
+	}
+
+	rescored := stored
+	rescored.Breakdown = breakdown
+	rescored.Pending = false
+	rescored.ComputedAt = time.Now()
+	rescored.Rescored = true
+	s.receipts[id] = rescored
+	if err := s.save(); err != nil {
+		s.receipts[id] = stored
+		s.mu.Unlock()
+		return 0, true, err
+	}
+	s.mu.Unlock()
+
+	// Rescoring a pending receipt completes it in place of the worker, so it
+	// is announced like one the worker scored.
+	if stored.Pending {
+		receiptsProcessed.Inc()
+		pointsAwarded.Observe(float64(breakdown.Total))
+		if s.onAdd != nil {
+			s.onAdd(id, stored.Receipt, breakdown.Total)
+		}
+	}
+	return breakdown.Total, true, nil
+}
+
+func (s *ReceiptStore) GetPoints(id string) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	stored, exists := s.receipts[id]
+	if stored.Pending {
+		return 0, true, ErrPending
+	}
+	return stored.Breakdown.Total, exists, nil
+}
+
+// GetScore returns a receipt's points along with when they were computed.
+// Receipts stored before computation times were kept report their creation
+// time.
+func (s *ReceiptStore) GetScore(id string) (ScoreInfo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	stored, exists := s.receipts[id]
+	if !exists {
+		return ScoreInfo{}, false, nil
+	}
+	if stored.Pending {
+		return ScoreInfo{}, true, ErrPending
+	}
+	computedAt := stored.ComputedAt
+	if computedAt.IsZero() {
+		computedAt = stored.CreatedAt
+	}
+	return ScoreInfo{Points: stored.Breakdown.Total, ComputedAt: computedAt, Rescored: stored.Rescored}, true, nil
+}
+
+// LookupKey returns the ID of the receipt stored under an idempotency key,
+// reporting false if there is none.
+func (s *ReceiptStore) LookupKey(idempotencyKey string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	id, exists := s.keys[idempotencyKey]
+	return id, exists && idempotencyKey != "", nil
+}
+
+func (s *ReceiptStore) GetBreakdown(id string) (scoring.PointsBreakdown, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	stored, exists := s.receipts[id]
+	if stored.Pending {
+		return scoring.PointsBreakdown{}, true, ErrPending
+	}
+	return stored.Breakdown, exists, nil
+}
+
+func (s *ReceiptStore) GetReceipt(id string) (scoring.Receipt, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	stored, exists := s.receipts[id]
+	return stored.Receipt, exists, nil
+}
+
+// Exists reports whether a receipt with the given ID is stored, whether or
+// not it has been scored yet.
+func (s *ReceiptStore) Exists(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	_, exists := s.receipts[id]
+	return exists, nil
+}
+
+// DeleteReceipt removes the receipt with the given ID and reports whether it
+// existed.
+func (s *ReceiptStore) DeleteReceipt(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	stored, exists := s.receipts[id]
+	if !exists {
+		return false, nil
+	}
+
+	s.unindex(id, stored)
+	if err := s.save(); err != nil {
+		s.index(id, stored)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReceiptSummary is the ID and points of a stored receipt.
+type ReceiptSummary struct {
+	ID     string `json:"id"`
+	Points int    `json:"points"`
+
+	// Pending is set for a receipt still waiting to be scored, whose points
+	// are zero until it is.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// ReceiptFilter narrows the receipts ListReceipts returns. The zero value
+// matches every receipt.
+type ReceiptFilter struct {
+	// Tags a receipt must carry, each with the same value.
+	Tags map[string]string
+
+	// From and To bound the purchase date, inclusive. Only the date is
+	// compared, and a zero time leaves that end open.
+	From, To time.Time
+}
+
+// empty reports whether the filter matches every receipt.
+func (f ReceiptFilter) empty() bool {
+	return len(f.Tags) == 0 && f.From.IsZero() && f.To.IsZero()
+}
+
+// matches reports whether receipt passes the filter. Stored purchase dates
+// are YYYY-MM-DD, so they compare correctly as strings.
+func (f ReceiptFilter) matches(receipt scoring.Receipt) bool {
+	if !f.From.IsZero() && receipt.PurchaseDate < f.From.Format(time.DateOnly) {
+		return false
+	}
+	if !f.To.IsZero() && receipt.PurchaseDate > f.To.Format(time.DateOnly) {
+		return false
+	}
+	for key, value := range f.Tags {
+		if tag, ok := receipt.Tags[key]; !ok || tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// filterPage returns the summaries of the entries matching filter, skipping
+// the first offset and keeping up to limit, along with how many matched.
+func filterPage(entries []receiptEntry, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int) {
+	page := make([]ReceiptSummary, 0, min(limit, len(entries)))
+	total := 0
+	for _, entry := range entries {
+		if !filter.matches(entry.stored.Receipt) {
+			continue
+		}
+		if total >= offset && len(page) < limit {
+			page = append(page, ReceiptSummary{ID: entry.id, Points: entry.stored.Breakdown.Total, Pending: entry.stored.Pending})
+		}
+		total++
+	}
+	return page, total
+}
+
+// ListReceipts returns up to limit receipts matching filter in insertion
+// order, skipping the first offset, along with the total number that match.
+func (s *ReceiptStore) ListReceipts(limit, offset int, filter ReceiptFilter) ([]ReceiptSummary, int, error) {
+	if !filter.empty() {
+		page, total := filterPage(s.snapshot(), filter, limit, offset)
+		return page, total, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	total := len(s.order)
+	start := min(offset, total)
+	end := min(start+limit, total)
+
+	page := make([]ReceiptSummary, 0, end-start)
+	for _, id := range s.order[start:end] {
+		stored := s.receipts[id]
+		page = append(page, ReceiptSummary{ID: id, Points: stored.Breakdown.Total, Pending: stored.Pending})
+	}
+	return page, total, nil
+}
+
+// receiptEntry is a stored receipt together with its ID.
+type receiptEntry struct {
+	id     string
+	stored storedReceipt
+}
+
+// snapshot copies the stored receipts in insertion order.
+func (s *ReceiptStore) snapshot() []receiptEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	entries := make([]receiptEntry, len(s.order))
+	for i, id := range s.order {
+		entries[i] = receiptEntry{id: id, stored: s.receipts[id]}
+	}
+	return entries
+}
+
+// WalkReceipts calls fn for every stored receipt in insertion order, stopping
+// at the first error fn returns. The receipts are snapshotted first, so fn
+// runs without holding the lock and may be slow.
+func (s *ReceiptStore) WalkReceipts(fn func(id string, receipt scoring.Receipt, points int) error) error {
+	for _, entry := range s.snapshot() {
+		if err := fn(entry.id, entry.stored.Receipt, entry.stored.Breakdown.Total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReceiptStats summarizes the points of every stored receipt. The min, max
+// and average are zero when the store is empty.
+type ReceiptStats struct {
+	Count         int     `json:"count"`
+	TotalPoints   int     `json:"totalPoints"`
+	AveragePoints float64 `json:"averagePoints"`
+	MinPoints     int     `json:"minPoints"`
+	MaxPoints     int     `json:"maxPoints"`
+}
+
+// Stats computes aggregate statistics over the stored receipts.
+func (s *ReceiptStore) Stats() (ReceiptStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	var stats ReceiptStats
+	for _, stored := range s.receipts {
+		if stored.Pending {
+			continue
+		}
+		points := stored.Breakdown.Total
+		if stats.Count == 0 || points < stats.MinPoints {
+			stats.MinPoints = points
+		}
+		if stats.Count == 0 || points > stats.MaxPoints {
+			stats.MaxPoints = points
+		}
+		stats.Count++
+		stats.TotalPoints += points
+	}
+	if stats.Count > 0 {
+		stats.AveragePoints = float64(stats.TotalPoints) / float64(stats.Count)
+	}
+	return stats, nil
+}
+
+// RetailerSummary aggregates the receipts stored for one retailer.
+type RetailerSummary struct {
+	Points int `json:"points"`
+	Count  int `json:"count"`
+}
+
+// SummaryByRetailer totals the points and receipts stored for each retailer
+// name, keyed by its retailerKey.
+func (s *ReceiptStore) SummaryByRetailer() (map[string]RetailerSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	summary := make(map[string]RetailerSummary)
+	for _, stored := range s.receipts {
+		if stored.Pending {
+			continue
+		}
+		key := retailerKey(stored.Receipt.Retailer)
+		retailer := summary[key]
+		retailer.Points += stored.Breakdown.Total
+		retailer.Count++
+		summary[key] = retailer
+	}
+	return summary, nil
+}
+
+// Clear removes every stored receipt and returns how many were removed.
+func (s *ReceiptStore) Clear() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipts, order, keys, hashes := s.receipts, s.order, s.keys, s.hashes
+	s.receipts = make(map[string]storedReceipt)
+	s.order = nil
+	s.keys = make(map[string]string)
+	s.hashes = make(map[string]string)
+	if err := s.save(); err != nil {
+		s.receipts, s.order, s.keys, s.hashes = receipts, order, keys, hashes
+		return 0, err
+	}
+	s.expired = make(map[string]time.Time)
+	s.graves = nil
+
+	return len(receipts), nil
+}
+
+// Flush writes the store's current contents to its data file and returns how
+// many receipts it wrote, or ErrNotPersisted if the store has no data file.
+func (s *ReceiptStore) Flush() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return 0, ErrNotPersisted
+	}
+	if err := s.save(); err != nil {
+		return 0, err
+	}
+	return len(s.receipts), nil
+}
+
+// load reads previously persisted receipts into memory. A missing file is
+// not an error; it simply means nothing has been stored yet.
+func (s *ReceiptStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.receipts); err != nil {
+		return err
+	}
+
+	for id, stored := range s.receipts {
+		s.index(id, stored)
+	}
+	slices.SortFunc(s.order, func(a, b string) int {
+		return s.receipts[a].CreatedAt.Compare(s.receipts[b].CreatedAt)
+	})
+	s.expire()
+	return nil
+}
+
+// index records a stored receipt and its idempotency key and content hash
+// lookups, including any alias keys. Callers must hold s.mu.
+func (s *ReceiptStore) index(id string, stored storedReceipt) {
+	s.receipts[id] = stored
+	s.order = append(s.order, id)
+	if stored.IdempotencyKey != "" {
+		s.keys[stored.IdempotencyKey] = id
+	}
+	for _, key := range stored.AliasKeys {
+		s.keys[key] = id
+	}
+	if s.dedup {
+		s.hashes[contentHash(stored.Receipt)] = id
+	}
+}
+
+// unindex reverses index. Callers must hold s.mu.
+func (s *ReceiptStore) unindex(id string, stored storedReceipt) {
+	if i := slices.Index(s.order, id); i >= 0 {
+		s.order = slices.Delete(s.order, i, i+1)
+	}
+	s.unindexLookups(id, stored)
+}
+
+// unindexLookups removes a receipt and its key and hash lookups, leaving
+// s.order to the caller. Callers must hold s.mu.
+func (s *ReceiptStore) unindexLookups(id string, stored storedReceipt) {
+	delete(s.receipts, id)
+	for _, key := range append([]string{stored.IdempotencyKey}, stored.AliasKeys...) {
+		if s.keys[key] == id {
+			delete(s.keys, key)
+		}
+	}
+	if s.dedup {
+		if hash := contentHash(stored.Receipt); s.hashes[hash] == id {
+			delete(s.hashes, hash)
+		}
+	}
+}
+
+// retailerKey returns the retailer name with surrounding whitespace trimmed
+// and inner runs of whitespace collapsed to one space, so "  Target " and
+// "Target" are grouped and deduplicated together. Stored receipts keep the
+// name as submitted.
+func retailerKey(retailer string) string {
+	return scoring.NormalizeRetailer(retailer)
+}
+
+// contentHash returns a stable hash of a receipt's content, used to detect
+// duplicate submissions. The retailer is compared by its retailerKey, and
+// tags are ignored, so a duplicate keeps the tags it was first stored with.
+func contentHash(receipt scoring.Receipt) string {
+	receipt.Retailer = retailerKey(receipt.Retailer)
+	receipt.Tags = nil
+	data, _ := json.Marshal(receipt)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the receipts to a temporary file and renames it over the data
+// file so a crash mid-write never leaves a truncated file behind. Callers
+// must hold s.mu.
+func (s *ReceiptStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.receipts)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Without the sync, a crash soon after the rename can leave the data
+	// file empty or truncated, since the rename may reach the disk first.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.unsaved = false
+	return nil
+}
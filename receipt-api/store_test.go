@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"receipt-api/scoring"
+)
+
+// testReceipt returns a valid receipt whose content depends on retailer.
+func testReceipt(retailer string) scoring.Receipt {
+	return scoring.Receipt{
+		Retailer:     retailer,
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []scoring.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+}
+
+// openTestStores opens each store backend with opts, closing them when the
+// test ends.
+func openTestStores(t *testing.T, opts StoreOptions) map[string]Store {
+	t.Helper()
+	stores := make(map[string]Store)
+
+	memory, err := NewReceiptStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["memory"] = memory
+
+	shardedOpts := opts
+	shardedOpts.Shards = 4
+	sharded, err := NewShardedStore(shardedOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["sharded"] = sharded
+
+	sqliteOpts := opts
+	sqliteOpts.Path = filepath.Join(t.TempDir(), "receipts.db")
+	sqlite, err := NewSQLiteStore(sqliteOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["sqlite"] = sqlite
+
+	t.Cleanup(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	})
+	return stores
+}
+
+func TestStoreUsesNewID(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{StoreMemory, StoreSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			n := 0
+			opts := StoreOptions{
+				Rules: scoring.DefaultRulesConfig(),
+				NewID: func() string { n++; return "receipt-" + strconv.Itoa(n) },
+			}
+			if backend == StoreSQLite {
+				opts.Path = filepath.Join(t.TempDir(), "receipts.db")
+			}
+			store, err := OpenStore(backend, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			for i, retailer := range []string{"Target", "Walmart"} {
+				want := "receipt-" + strconv.Itoa(i+1)
+				if id, _, err := store.AddReceipt(ctx, testReceipt(retailer), ""); err != nil || id != want {
+					t.Errorf("AddReceipt = %q, %v; want %q", id, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetailerSpellingsGroupTogether(t *testing.T) {
+	ctx := context.Background()
+	opts := StoreOptions{Deduplicate: true, Rules: scoring.DefaultRulesConfig()}
+	for name, store := range openTestStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			original, _, err := store.AddReceipt(ctx, testReceipt("Target"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The same receipt with a padded name is a duplicate...
+			if id, _, err := store.AddReceipt(ctx, testReceipt(" Target "), ""); err != nil || id != original {
+				t.Errorf("padded duplicate got %q, %v; want %q", id, err, original)
+			}
+			// ...and a different one is summarized under the same retailer.
+			other := testReceipt("  Target")
+			other.PurchaseDate = "2022-01-02"
+			if _, _, err := store.AddReceipt(ctx, other, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := store.SummaryByRetailer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(summary) != 1 || summary["Target"].Count != 2 {
+				t.Errorf("SummaryByRetailer = %v, want 2 receipts under \"Target\"", summary)
+			}
+		})
+	}
+}
+
+func TestDeduplicatedSubmissionRecordsIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	opts := StoreOptions{Deduplicate: true, Rules: scoring.DefaultRulesConfig()}
+	for name, store := range openTestStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			original, _, err := store.AddReceipt(ctx, testReceipt("Target"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, _, err := store.AddReceipt(ctx, testReceipt("Target"), "k-123")
+			if err != nil || id != original {
+				t.Fatalf("duplicate got %q, %v; want the original %q", id, err, original)
+			}
+			if id, exists, err := store.LookupKey("k-123"); err != nil || !exists || id != original {
+				t.Errorf("LookupKey = %q, %v, %v; want %q", id, exists, err, original)
+			}
+
+			// A retry with the same key and corrected content is a replay.
+			id, _, err = store.AddReceipt(ctx, testReceipt("Walmart"), "k-123")
+			if err != nil || id != original {
+				t.Errorf("retry got %q, %v; want the original %q", id, err, original)
+			}
+
+			if deleted, err := store.DeleteReceipt(original); err != nil || !deleted {
+				t.Fatalf("DeleteReceipt = %v, %v", deleted, err)
+			}
+			if _, exists, err := store.LookupKey("k-123"); err != nil || exists {
+				t.Errorf("LookupKey after delete = %v, %v; want not found", exists, err)
+			}
+		})
+	}
+}
+
+// TestConcurrentAccess exercises the stores from many goroutines at once; run
+// it with go test -race.
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	opts := StoreOptions{Deduplicate: true, Rules: scoring.DefaultRulesConfig()}
+	for name, store := range openTestStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			const workers, perWorker = 8, 25
+			var wg sync.WaitGroup
+			errs := make(chan error, workers*perWorker)
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range perWorker {
+						retailer := "Retailer " + strconv.Itoa(w) + "-" + strconv.Itoa(i%5)
+						id, points, err := store.AddReceipt(ctx, testReceipt(retailer), "")
+						if err != nil {
+							errs <- err
+							return
+						}
+						if got, exists, err := store.GetPoints(id); err != nil || !exists || got != points {
+							errs <- fmt.Errorf("GetPoints(%s) = %d, %v, %v; want %d", id, got, exists, err, points)
+							return
+						}
+						if _, _, err := store.Rescore(ctx, id); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// Each worker stored five distinct receipts; the rest were duplicates.
+			if _, total, err := store.ListReceipts(1, 0, ReceiptFilter{}); err != nil || total != workers*5 {
+				t.Errorf("ListReceipts total = %d, %v; want %d", total, err, workers*5)
+			}
+		})
+	}
+}
+
+func TestShardedStoreReplaysKeyWithDifferentContent(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewShardedStore(StoreOptions{Deduplicate: true, Shards: 8, Rules: scoring.DefaultRulesConfig()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	original, _, err := store.AddReceipt(ctx, testReceipt("Target"), "k-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whatever shards the corrected contents hash to, the key wins.
+	for _, retailer := range []string{"Walmart", "Costco", "Kroger", "Aldi", "Safeway"} {
+		if id, _, err := store.AddReceipt(ctx, testReceipt(retailer), "k-1"); err != nil || id != original {
+			t.Errorf("replay with %s got %q, %v; want the original %q", retailer, id, err, original)
+		}
+	}
+	if _, total, _ := store.ListReceipts(10, 0, ReceiptFilter{}); total != 1 {
+		t.Errorf("stored %d receipts, want 1", total)
+	}
+
+	// A keyed duplicate of an unkeyed receipt is found on the content's shard.
+	unkeyed, _, err := store.AddReceipt(ctx, testReceipt("Kmart"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := store.AddReceipt(ctx, testReceipt("Kmart"), "k-2"); err != nil || id != unkeyed {
+		t.Errorf("keyed duplicate got %q, %v; want %q", id, err, unkeyed)
+	}
+}
+
+// BenchmarkStoreParallel compares a single-lock ReceiptStore with sharded
+// stores under concurrent adds and lookups; compare with -cpu 1,4,16.
+func BenchmarkStoreParallel(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			store, err := OpenStore(StoreMemory, StoreOptions{Deduplicate: true, Shards: shards, Rules: scoring.DefaultRulesConfig()})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+
+			var n atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := context.Background()
+				for pb.Next() {
+					id, _, err := store.AddReceipt(ctx, testReceipt("Retailer "+strconv.FormatInt(n.Add(1), 10)), "")
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					store.GetPoints(id)
+				}
+			})
+		})
+	}
+}
+
+func TestExpireEvictsOldestReceipts(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewReceiptStore(StoreOptions{Deduplicate: true, TTL: time.Hour, Rules: scoring.DefaultRulesConfig()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var ids []string
+	for _, retailer := range []string{"Target", "Walmart", "Costco"} {
+		id, _, err := store.AddReceipt(ctx, testReceipt(retailer), "k-"+retailer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Age the first two past the TTL.
+	store.mu.Lock()
+	for _, id := range ids[:2] {
+		stored := store.receipts[id]
+		stored.CreatedAt = stored.CreatedAt.Add(-2 * time.Hour)
+		store.receipts[id] = stored
+	}
+	store.expire()
+	order, keys, hashes := slices.Clone(store.order), len(store.keys), len(store.hashes)
+	store.mu.Unlock()
+
+	if !slices.Equal(order, ids[2:]) {
+		t.Errorf("order after expiry = %v, want %v", order, ids[2:])
+	}
+	if keys != 1 || hashes != 1 {
+		t.Errorf("%d keys and %d hashes left, want 1 of each", keys, hashes)
+	}
+	for _, id := range ids[:2] {
+		if expired, _ := store.Expired(id); !expired {
+			t.Errorf("%s not reported expired", id)
+		}
+	}
+}
+
+func TestShardedStoreBoundsNewID(t *testing.T) {
+	ctx := context.Background()
+	n := 0
+	store, err := NewShardedStore(StoreOptions{
+		Shards: 4,
+		Rules:  scoring.DefaultRulesConfig(),
+		NewID:  func() string { n++; return "receipt-" + strconv.Itoa(n) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// A deterministic sequence works: IDs for other shards are skipped.
+	for _, retailer := range []string{"Target", "Walmart", "Costco", "Kroger", "Aldi"} {
+		id, points, err := store.AddReceipt(ctx, testReceipt(retailer), "")
+		if err != nil {
+			t.Fatalf("%s: %v", retailer, err)
+		}
+		if got, exists, err := store.GetPoints(id); err != nil || !exists || got != points {
+			t.Errorf("%s: GetPoints(%q) = %d, %v, %v; want %d", retailer, id, got, exists, err, points)
+		}
+	}
+
+	// A constant ID belongs to one shard; receipts routed elsewhere fail
+	// rather than hang.
+	constant, err := NewShardedStore(StoreOptions{
+		Shards: 4,
+		Rules:  scoring.DefaultRulesConfig(),
+		NewID:  func() string { return "receipt-1" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer constant.Close()
+
+	failed := 0
+	for _, retailer := range []string{"Target", "Walmart", "Costco", "Kroger"} {
+		if _, _, err := constant.AddReceipt(ctx, testReceipt(retailer), ""); err != nil {
+			if !errors.Is(err, errShardID) {
+				t.Errorf("%s: err = %v, want errShardID", retailer, err)
+			}
+			failed++
+		}
+	}
+	if failed != 3 {
+		t.Errorf("%d of 4 round-robin adds failed, want the 3 on other shards", failed)
+	}
+}
+
+func TestStorePersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{StoreMemory, StoreSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			opts := StoreOptions{
+				Path:        filepath.Join(t.TempDir(), "receipts"),
+				Deduplicate: true,
+				Rules:       scoring.DefaultRulesConfig(),
+			}
+			store, err := OpenStore(backend, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, points, err := store.AddReceipt(ctx, testReceipt("Target"), "k-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err = OpenStore(backend, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			if got, exists, err := store.GetPoints(id); err != nil || !exists || got != points {
+				t.Errorf("GetPoints = %d, %v, %v; want %d", got, exists, err, points)
+			}
+			if receipt, exists, err := store.GetReceipt(id); err != nil || !exists || receipt.Retailer != "Target" {
+				t.Errorf("GetReceipt = %+v, %v, %v", receipt, exists, err)
+			}
+			if got, exists, err := store.LookupKey("k-1"); err != nil || !exists || got != id {
+				t.Errorf("LookupKey = %q, %v, %v; want %q", got, exists, err, id)
+			}
+			// The reloaded receipt still deduplicates new submissions.
+			if got, _, err := store.AddReceipt(ctx, testReceipt("Target"), ""); err != nil || got != id {
+				t.Errorf("duplicate after reopen got %q, %v; want %q", got, err, id)
+			}
+		})
+	}
+}
+
+func TestFlushReportsCount(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, opts := range map[string]StoreOptions{
+		"memory":  {},
+		"file":    {Path: filepath.Join(dir, "receipts.json")},
+		"sharded": {Shards: 4},
+		"sqlite":  {Path: filepath.Join(dir, "receipts.db")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Rules = scoring.DefaultRulesConfig()
+			backend := StoreMemory
+			if name == "sqlite" {
+				backend = StoreSQLite
+			}
+			store, err := OpenStore(backend, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			for _, retailer := range []string{"Target", "Walmart"} {
+				if _, _, err := store.AddReceipt(ctx, testReceipt(retailer), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			count, err := store.Flush()
+			if opts.Path == "" {
+				if !errors.Is(err, ErrNotPersisted) {
+					t.Errorf("Flush = %d, %v; want ErrNotPersisted", count, err)
+				}
+				return
+			}
+			if err != nil || count != 2 {
+				t.Errorf("Flush = %d, %v; want 2", count, err)
+			}
+		})
+	}
+}
+
+// markPending puts a stored receipt back in the state asynchronous scoring
+// leaves it in before the worker runs, without racing the worker.
+func markPending(t *testing.T, store Store, id string) {
+	t.Helper()
+	switch s := store.(type) {
+	case *ReceiptStore:
+		s.mu.Lock()
+		stored := s.receipts[id]
+		stored.Pending = true
+		stored.Breakdown = scoring.PointsBreakdown{}
+		s.receipts[id] = stored
+		s.mu.Unlock()
+	case *SQLiteStore:
+		if _, err := s.db.Exec(`UPDATE receipts SET pending = 1, points = 0 WHERE id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("cannot mark %T receipts pending", store)
+	}
+}
+
+func TestPendingReceipts(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{StoreMemory, StoreSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			var announced []int
+			opts := StoreOptions{
+				Deduplicate: true,
+				Rules:       scoring.DefaultRulesConfig(),
+				OnAdd: func(id string, receipt scoring.Receipt, points int) {
+					announced = append(announced, points)
+				},
+			}
+			if backend == StoreSQLite {
+				opts.Path = filepath.Join(t.TempDir(), "receipts.db")
+			}
+			store, err := OpenStore(backend, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			id, points, err := store.AddReceipt(ctx, testReceipt("Target"), "k-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			markPending(t, store, id)
+			announced = nil
+
+			// Replays and duplicates report the receipt as pending, not as
+			// worth zero points.
+			if got, _, err := store.AddReceipt(ctx, testReceipt("Target"), "k-1"); got != id || !errors.Is(err, ErrPending) {
+				t.Errorf("replay = %q, %v; want %q, ErrPending", got, err, id)
+			}
+			if got, _, err := store.AddReceipt(ctx, testReceipt("Target"), ""); got != id || !errors.Is(err, ErrPending) {
+				t.Errorf("duplicate = %q, %v; want %q, ErrPending", got, err, id)
+			}
+
+			page, _, err := store.ListReceipts(10, 0, ReceiptFilter{})
+			if err != nil || len(page) != 1 || !page[0].Pending {
+				t.Errorf("ListReceipts = %+v, %v; want the receipt flagged pending", page, err)
+			}
+			if stats, err := store.Stats(); err != nil || stats.Count != 0 {
+				t.Errorf("Stats = %+v, %v; want pending receipt left out", stats, err)
+			}
+
+			// Rescoring completes the receipt and announces it, once.
+			for range 2 {
+				if got, exists, err := store.Rescore(ctx, id); err != nil || !exists || got != points {
+					t.Fatalf("Rescore = %d, %v, %v; want %d", got, exists, err, points)
+				}
+			}
+			if len(announced) != 1 || announced[0] != points {
+				t.Errorf("OnAdd calls = %v; want [%d]", announced, points)
+			}
+			page, _, err = store.ListReceipts(10, 0, ReceiptFilter{})
+			if err != nil || len(page) != 1 || page[0].Pending || page[0].Points != points {
+				t.Errorf("ListReceipts after rescore = %+v, %v", page, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withRequestTimeout gives each request's context a deadline of d, which the
+// store operations give up at. Like http.TimeoutHandler, it answers 503 as
+// soon as the deadline passes, whether or not the handler has returned, and
+// discards whatever the handler writes afterwards; until then the response is
+// buffered. Requests for which exempt reports true are passed straight to h.
+//
+// It wraps the whole router rather than being a gin middleware because the
+// handler may outlive the response, and gin reuses a request's context once
+// the router returns.
+func withRequestTimeout(h http.Handler, d time.Duration, exempt func(*http.Request) bool, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			h.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client went away; there is no one to answer.
+				return
+			}
+			logger.Warn("request timed out", "method", r.Method, "path", r.URL.Path, "timeout", d)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(errorBody(CodeTimeout, "Request timed out"))
+		}
+	})
+}
+
+// timeoutExempt reports whether a request runs without a deadline: the
+// probes, which must stay cheap, and the streaming import and export, which
+// write as they go and may rightly take longer than any one request.
+func timeoutExempt(r *http.Request) bool {
+	switch strings.TrimPrefix(r.URL.Path, "/v1") {
+	case "/health", "/ready", "/receipts/import", "/receipts/export.csv":
+		return true
+	}
+	return false
+}
+
+// timeoutWriter buffers a response until withRequestTimeout decides whether
+// to send it. Writes after the deadline fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(data)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// storeFailure picks the response for a failed store operation: 503 if it
+// gave up at the request deadline, 500 otherwise.
+func storeFailure(err error, message string) (int, gin.H) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable, errorBody(CodeTimeout, "Request timed out")
+	}
+	return http.StatusInternalServerError, errorBody(CodeInternal, message)
+}
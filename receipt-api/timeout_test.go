@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutAnswersStuckHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("too late"))
+	})
+	handler := withRequestTimeout(stuck, 20*time.Millisecond, timeoutExempt, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/receipts", nil))
+		done <- rec
+	}()
+
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d, want 503", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), CodeTimeout) {
+			t.Errorf("body = %q, want code %s", rec.Body.String(), CodeTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stuck handler held the response past the deadline")
+	}
+}
+
+func TestRequestTimeoutPassesFastResponse(t *testing.T) {
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	handler := withRequestTimeout(fast, time.Second, timeoutExempt, slog.Default())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/receipts/process", nil))
+	if rec.Code != http.StatusCreated || rec.Body.String() != "done" || rec.Header().Get("X-Test") != "yes" {
+		t.Errorf("got %d %q %v, want the handler's response", rec.Code, rec.Body.String(), rec.Header())
+	}
+}
+
+func TestRequestTimeoutSkipsStreamingEndpoints(t *testing.T) {
+	for _, path := range []string{"/v1/receipts/import", "/receipts/import", "/v1/receipts/export.csv", "/health"} {
+		slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); ok {
+				t.Errorf("%s: request has a deadline", path)
+			}
+			// Streaming handlers flush, which a buffered writer can't.
+			w.(http.Flusher).Flush()
+		})
+		handler := withRequestTimeout(slow, time.Millisecond, timeoutExempt, slog.Default())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", path, rec.Code)
+		}
+	}
+}
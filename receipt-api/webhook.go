@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"receipt-api/scoring"
+)
+
+const (
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+	webhookTimeout  = 5 * time.Second
+
+	// webhookQueueSize is how many deliveries may wait for the worker before
+	// new ones are dropped.
+	webhookQueueSize = 1000
+)
+
+// webhook posts a notification to a URL for every newly stored receipt.
+// Deliveries are queued for a background worker and retried a few times, so
+// a slow or failing endpoint never holds up the response to the client.
+type webhook struct {
+	url     string
+	client  *http.Client
+	logger  *slog.Logger
+	queue   chan webhookDelivery
+	done    chan struct{} // closed by Close to have the worker drain and stop
+	stopped chan struct{} // closed by the worker once it has stopped
+}
+
+// webhookDelivery is a queued notification.
+type webhookDelivery struct {
+	id   string
+	body []byte
+}
+
+// newWebhook returns a webhook for url and starts its worker, which runs
+// until Close.
+func newWebhook(url string, logger *slog.Logger) *webhook {
+	w := &webhook{
+		url:     url,
+		client:  &http.Client{Timeout: webhookTimeout},
+		logger:  logger.With("webhook", url),
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.work()
+	return w
+}
+
+// notify queues a delivery for a stored receipt. It matches
+// StoreOptions.OnAdd, so it must not block: when the queue is full the
+// delivery is dropped and logged.
+func (w *webhook) notify(id string, receipt scoring.Receipt, points int) {
+	body, err := json.Marshal(map[string]any{"id": id, "points": points, "retailer": receipt.Retailer})
+	if err != nil {
+		w.logger.Error("failed to encode webhook payload", "receiptId", id, "error", err)
+		return
+	}
+	select {
+	case w.queue <- webhookDelivery{id: id, body: body}:
+	default:
+		w.logger.Error("webhook queue full, delivery dropped", "receiptId", id)
+	}
+}
+
+// work delivers queued notifications one at a time until Close, then
+// delivers whatever is still queued and stops.
+func (w *webhook) work() {
+	defer close(w.stopped)
+	for {
+		select {
+		case d := <-w.queue:
+			w.deliver(d.id, d.body)
+		case <-w.done:
+			for {
+				select {
+				case d := <-w.queue:
+					w.deliver(d.id, d.body)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close stops the worker once the queued deliveries are done, waiting for it
+// until ctx ends. Deliveries queued after Close are not sent.
+func (w *webhook) Close(ctx context.Context) error {
+	close(w.done)
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver posts body, retrying with a growing delay until the endpoint
+// accepts it with a 2xx status or the attempts run out.
+func (w *webhook) deliver(id string, body []byte) {
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err := w.post(body)
+		if err == nil {
+			return
+		}
+		w.logger.Warn("webhook delivery failed", "receiptId", id, "attempt", attempt, "error", err)
+		if attempt < webhookAttempts {
+			time.Sleep(webhookBackoff * time.Duration(attempt))
+		}
+	}
+	w.logger.Error("webhook delivery abandoned", "receiptId", id, "attempts", webhookAttempts)
+}
+
+func (w *webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliversQueuedOnClose(t *testing.T) {
+	var delivered atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer server.Close()
+
+	hook := newWebhook(server.URL, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for i := range 10 {
+		hook.notify(strconv.Itoa(i), testReceipt("Target"), 10)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hook.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if got := delivered.Load(); got != 10 {
+		t.Errorf("delivered %d notifications before Close returned, want 10", got)
+	}
+}
+
+func TestWebhookDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var delivered atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		delivered.Add(1)
+	}))
+	defer server.Close()
+
+	hook := newWebhook(server.URL, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	// The worker is stuck on the first delivery, so the rest fill the queue
+	// and notify must drop the overflow rather than block.
+	const sent = webhookQueueSize + 10
+	returned := make(chan struct{})
+	go func() {
+		for i := range sent {
+			hook.notify(strconv.Itoa(i), testReceipt("Target"), 10)
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify blocked on a full queue")
+	}
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := hook.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if got := delivered.Load(); got == 0 || got > webhookQueueSize+1 {
+		t.Errorf("delivered %d of %d notifications, want at most %d", got, sent, webhookQueueSize+1)
+	}
+}